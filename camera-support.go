@@ -19,8 +19,13 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"embed"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
@@ -31,96 +36,388 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/beevik/etree"
 )
 
+// Column headers per language, see -lang. English is built in
+//
+//go:embed translations/*.json
+var translations embed.FS
+
+// Set at build time, see the Makefile
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
 type camera struct {
-	Maker         string
-	Model         string
-	Aliases       []string
-	Formats       []string // RawSpeed modes
-	WBPresets     bool
-	NoiseProfiles bool
-	RSSupported   string // RawSpeed support
-	Decoder       string // RawSpeed | LibRaw | Unknown
-	Debug         []string
+	Maker               string
+	Model               string
+	Aliases             []string
+	Formats             []string // RawSpeed modes
+	ExperimentalFormats []string // Modes marked experimental in -annotations
+	Hints               []string // RawSpeed hint names, for cameras needing special handling
+	Notes               []string // From -annotations
+	WBPresets           bool
+	NoiseProfiles       bool
+	NoiseProfileSource  []string // Noise profile files listing the camera: noiseprofiles, noiseprofiles-extra
+	RSSupported         string   // RawSpeed support
+	SupportStatus       string   // RSSupported normalized: true | false | partial. Empty if not in cameras.xml
+	Decoder             string   // RawSpeed | LibRaw | Partial | Unknown
+	IsAlias             bool     // Row generated from an alias by -explode-aliases
+	IsNew               bool     // Not in the -baseline merged data
+	Sources             []string // Sources listing the camera, named like their flags
+	Debug               []string
+}
+
+type dngCamera struct {
+	Maker string `json:"maker"`
+	Model string `json:"model"`
 }
 
+// Maker used for cameras where a source has no maker
+const unknownMaker = "(unknown maker)"
+
 type stats struct {
-	cameras             int
-	aliases             int
-	rawspeed            int
-	rawspeedPercent     int
-	libraw              int
-	librawPercent       int
-	supported           int
-	supportedPercent    int
-	unknown             int
-	unknownPercent      int
-	unsupported         int
-	unsupportedPercent  int
-	wbPresets           int
-	wbPresetsPercent    int
-	noiseProfiles       int
-	noiseProfilePercent int
+	cameras                   int
+	noMaker                   int
+	aliases                   int
+	marketedTotal             int // Cameras plus aliases, counting rebadged bodies separately
+	rawspeed                  int
+	rawspeedPercent           float64
+	libraw                    int
+	librawPercent             float64
+	partial                   int
+	partialPercent            float64
+	supported                 int
+	supportedPercent          float64
+	unknown                   int
+	unknownPercent            float64
+	unsupported               int
+	unsupportedPercent        float64
+	wbPresets                 int
+	wbPresetsPercent          float64
+	noiseProfiles             int
+	noiseProfilePercent       float64
+	formatsAverage            float64   // Distinct formats per supported camera in cameras.xml
+	multiFormat               int       // Supported cameras with more than one format
+	calibration               [2][2]int // Supported cameras by [has WB presets][has noise profiles]
+	experimentalFormats       int       // Modes marked experimental in -annotations
+	calibrationWithoutDecoder int       // Cameras with WB presets or noise profiles but no decoder, whatever the support filters
+}
+
+// Where a source was read from, for the run summary
+type sourceInfo struct {
+	Path   string `json:"path"`
+	Origin string `json:"origin"` // fetched | local
+	ETag   string `json:"etag,omitempty"`
+	Bytes  int    `json:"bytes"`
+}
+
+type runSummary struct {
+	Started  time.Time      `json:"started"`
+	Duration string         `json:"duration"`
+	Sources  []sourceInfo   `json:"sources"`
+	Counts   map[string]int `json:"counts"`
+	Warnings map[string]int `json:"warnings"` // Per category
+}
+
+// Every source read during the run, in order
+var sourceLog = []sourceInfo{}
+
+// Source pinned to a commit, for -write-lock and -read-lock. URL is the unpinned location
+type lockEntry struct {
+	Source string `json:"source"` // Option name, e.g. rawspeed
+	URL    string `json:"url"`
+	Ref    string `json:"ref,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	ETag   string `json:"etag,omitempty"`
+}
+
+// Sources pinned by -read-lock, by option name, so -write-lock keeps their entries
+var pinnedSources = map[string]lockEntry{}
+
+// Shared by all fetches of sources
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Limits concurrent HTTP fetches, see -max-concurrency
+var fetchSlots = make(chan struct{}, 4)
+
+// Bypass HTTP caches, see -fresh
+var freshFetch = false
+
+// Retries of a fetch rate limited with status 429
+const maxFetchRetries = 3
+
+type problem struct {
+	Category string `json:"category"`
+	Severity string `json:"severity"`
+	Maker    string `json:"maker"`
+	Model    string `json:"model"`
+	Message  string `json:"message"`
+}
+
+// Every warning raised during the run, in order
+var problems = []problem{}
+
+// Number of warnings raised per category. Keys are the valid categories for -fail-on
+var warningCounts = map[string]int{
+	"dng-orphan":             0,
+	"equivalent-not-found":   0,
+	"annotation-not-found":   0,
+	"empty-maker":            0,
+	"near-duplicate":         0,
+	"include-not-found":      0,
+	"decoder-downgrade":      0,
+	"alias-calibration":      0,
+	"formats-no-decoder":     0,
+	"max-rows":               0,
+	"lock-outdated":          0,
+	"alias-not-found":        0,
+	"maker-variant":          0,
+	"calibration-no-decoder": 0,
+	"shared-alias":           0,
+	"shared-model":           0,
 }
 
 type options struct {
-	rawspeedPath      string
-	rawspeedDNGPath   string
-	librawPath        string
-	wbpresetsPath     string
-	noiseprofilesPath string
-	stats             struct {
-		stdout bool
-		table  bool
-		text   bool
-	}
-	format      string
-	thFormatStr []string
-	segments    int
-	fields      []string
-	bools       []string
-	escape      bool
-	unknown     bool
-	unsupported bool
-	output      string
+	rawspeedPath           string
+	rawspeedDNGPath        string
+	dngDelimiter           rune
+	dngHasHeader           string
+	librawPath             string
+	wbpresetsPath          string
+	noiseprofilesPath      string
+	noiseprofilesExtraPath string
+	strictSchema           bool
+	urlBase                string
+	writeLock              string
+	cpuProfile             string
+	memProfile             string
+	readLock               string
+	dataDir                string
+	oldWBPresetsPath       string
+	oldNoiseProfilesPath   string
+	equivalentsPath        string
+	makerCanonicalPath     string
+	annotationsPath        string
+	aliasesExtraPath       string
+	includePath            string
+	saveMerged             string
+	loadMerged             string
+	baseline               string
+	onlyNew                bool
+	formatFilter           *regexp.Regexp
+	minSize                map[string]int // Minimum download size per source, if length is unknown
+	stats                  struct {
+		stdout  bool
+		table   bool
+		text    bool
+		json    bool
+		formats bool
+		matrix  bool
+		compact bool
+	}
+	statsPrecision         int
+	statsOutput            string
+	rounding               string
+	lang                   string
+	noColor                bool
+	format                 string
+	thFormatStr            []string
+	segments               int
+	makerLimit             int
+	makerOrder             []string
+	makers                 []string
+	includeAliasesInSearch bool
+	sort                   string
+	fields                 []string
+	fieldsPreset           string
+	presetsPath            string
+	bools                  []string
+	escape                 bool
+	escapeMode             string
+	explodeAliases         bool
+	footer                 bool
+	legend                 string
+	decoderLabels          map[string]string // Keyed by lowercase decoder
+	decoderLabelsAll       bool
+	wrapAliases            int
+	dropEmptyModel         bool
+	annotate               bool
+	check                  bool
+	modelsOnly             bool
+	aboutList              bool
+	aboutAliases           bool
+	mergeNearDuplicates    bool
+	hideDefaultFormats     bool
+	emptyPlaceholder       string
+	nullEmpty              bool
+	unknown                bool
+	unsupported            bool
+	partial                bool
+	countOnly              bool
+	listMakers             bool
+	dumpMerged             bool
+	failOn                 []string
+	problems               string
+	minPerMaker            map[string]int
+	maxRowsWarn            int
+	summary                string
+	manifest               string
+	makerCounts            string
+	report                 string
+	splitBy                string
+	makerFilenamesPath     string
+	template               string
+	version                bool
+	output                 string
+	outputMode             os.FileMode
 }
 
-func main() {
-	columnHeaders := map[string]string{
-		"maker":         "Maker",
-		"model":         "Model",
-		"aliases":       "Aliases",
-		"formats":       "Formats",
-		"wbpresets":     "WB Presets",
-		"noiseprofiles": "Noise Profile",
-		"rssupported":   "RawSpeed Support",
-		"decoder":       "Decoder",
-		"debug":         "Debug",
+// Header text of each field, replaced in part by -lang
+func defaultColumnHeaders() map[string]string {
+	return map[string]string{
+		"maker":              "Maker",
+		"model":              "Model",
+		"aliases":            "Aliases",
+		"formats":            "Formats",
+		"wbpresets":          "WB Presets",
+		"noiseprofiles":      "Noise Profile",
+		"rssupported":        "RawSpeed Support",
+		"supportstatus":      "Support Status",
+		"hints":              "Hints",
+		"notes":              "Notes",
+		"decoder":            "Decoder",
+		"status":             "Status",
+		"isalias":            "Is Alias",
+		"isnew":              "New",
+		"noiseprofilesource": "Noise Profile Source",
+		"sources":            "Sources",
+		"debug":              "Debug",
 	}
+}
 
-	options := options{
-		format:      "md",
-		thFormatStr: []string{"%v (%v)", "%v (%v / %v%%)"},
-		fields:      []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder"},
-		bools:       []string{"Yes", "No"},
+// Option values before flags are parsed
+func defaultOptions() options {
+	return options{
+		format:       "md",
+		dngDelimiter: ',',
+		dngHasHeader: "auto",
+		escapeMode:   "strict",
+		thFormatStr:  []string{"%v (%v)", "%v (%v / %v%%)"},
+		fields:       []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder"},
+		bools:        []string{"Yes", "No"},
+		minPerMaker:  map[string]int{},
+		outputMode:   0666,
+		minSize: map[string]int{
+			"rawspeed":      65536,
+			"rawspeeddng":   0,
+			"libraw":        4096,
+			"wbpresets":     65536,
+			"noiseprofiles": 65536,
+		},
 	}
+}
+
+func main() {
+	columnHeaders := defaultColumnHeaders()
+	options := defaultOptions()
 
 	flag.StringVar(&options.rawspeedPath, "rawspeed", "https://raw.githubusercontent.com/darktable-org/rawspeed/develop/data/cameras.xml", "'cameras.xml' location.")
 	flag.StringVar(&options.rawspeedDNGPath, "rawspeeddng", "https://raw.githubusercontent.com/darktable-org/camera-support/main/rawspeed-dng.csv", "'rawspeed-dng.csv' location.")
+	flag.Func("dng-delimiter", "Field delimiter of 'rawspeed-dng.csv'. Use \"\\t\" for tab. (default \",\")", func(s string) error {
+		if s == "\\t" {
+			s = "\t"
+		}
+		if utf8.RuneCountInString(s) != 1 || strings.ContainsAny(s, "\"\r\n") {
+			return errors.New("Must be a single character, other than a quote or line break\n")
+		}
+		options.dngDelimiter, _ = utf8.DecodeRuneInString(s)
+		return nil
+	})
+	flag.Func("dng-has-header", "Whether the first row of 'rawspeed-dng.csv' is a header. \"auto\" skips it if it is exactly Maker and Model. <auto|true|false> (default auto)", func(s string) error {
+		s = strings.ToLower(s)
+		if s != "auto" && s != "true" && s != "false" {
+			return errors.New("Must be \"auto\", \"true\" or \"false\"\n")
+		}
+		options.dngHasHeader = s
+		return nil
+	})
 	flag.StringVar(&options.librawPath, "libraw", "https://raw.githubusercontent.com/darktable-org/darktable/master/src/imageio/imageio_libraw.c", "'imageio_libraw.c' location. If empty, LibRaw cameras will not be included.")
 	flag.StringVar(&options.wbpresetsPath, "wbpresets", "https://raw.githubusercontent.com/darktable-org/darktable/master/data/wb_presets.json", "'wb_presets.json' location.")
 	flag.StringVar(&options.noiseprofilesPath, "noiseprofiles", "https://raw.githubusercontent.com/darktable-org/darktable/master/data/noiseprofiles.json", "'noiseprofiles.json' location.")
+	flag.StringVar(&options.noiseprofilesExtraPath, "noiseprofiles-extra", "", "Second 'noiseprofiles.json' location, e.g. with auto-generated profiles. See the NoiseProfileSource field.")
+	flag.StringVar(&options.oldWBPresetsPath, "old-wbpresets", "", "Older 'wb_presets.json' location. Only output the calibration changes compared to -wbpresets, as Markdown.")
+	flag.StringVar(&options.oldNoiseProfilesPath, "old-noiseprofiles", "", "Older 'noiseprofiles.json' location. Only output the calibration changes compared to -noiseprofiles, as Markdown.")
+
+	flag.StringVar(&options.makerCanonicalPath, "maker-canonical", "", "JSON object of maker names to rewrite to a canonical name, e.g. {\"Panasonic Lumix\": \"Panasonic\"}.")
+	flag.StringVar(&options.equivalentsPath, "equivalents", "", "CSV file of Maker, Model and Equivalent columns. Each Equivalent camera is merged into Model.")
+
+	flag.StringVar(&options.includePath, "include", "", "CSV file of Maker and Model columns. Only these cameras are output and counted in statistics.")
+	flag.StringVar(&options.aliasesExtraPath, "aliases-extra", "", "JSON file of additional aliases for cameras, e.g. rebadged models missing upstream.")
+	flag.StringVar(&options.annotationsPath, "annotations", "", "JSON file of notes for cameras, shown in the Notes field.")
+	flag.StringVar(&options.saveMerged, "save-merged", "", "Save the merged camera data to this JSON file, for use with -load-merged.")
+	flag.StringVar(&options.loadMerged, "load-merged", "", "Load merged camera data saved with -save-merged, instead of reading the sources.")
+	flag.StringVar(&options.baseline, "baseline", "", "Merged camera data saved with -save-merged to compare against. Cameras not in it are new, see the IsNew field.")
+	flag.Func("format-filter", "Only output and count cameras with a format (RawSpeed mode) matching this regular expression.", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return fmt.Errorf("Invalid regular expression: %v\n", err)
+		}
+		options.formatFilter = re
+		return nil
+	})
+	flag.BoolVar(&options.onlyNew, "only-new", false, "Only output and count the cameras that aren't in -baseline.")
+
+	flag.StringVar(&options.dataDir, "data-dir", "", "darktable source tree to read cameras.xml, imageio_libraw.c, wb_presets.json and noiseprofiles.json from. Sources set explicitly are kept.")
+	flag.StringVar(&options.writeLock, "write-lock", "", "Write the commit and ETag each fetched source resolved to, to this file.")
+	flag.StringVar(&options.readLock, "read-lock", "", "Pin sources to the commits recorded by -write-lock in this file.")
+	flag.StringVar(&options.urlBase, "url-base", "", "Replaces https://raw.githubusercontent.com in the default source locations, e.g. for a mirror. Sources set explicitly are kept.")
+	flag.BoolVar(&options.strictSchema, "strict-schema", false, "Fail if cameras.xml, wb_presets.json or noiseprofiles.json don't have the structure the parser expects.")
+	flag.Func("min-size", "Minimum size in bytes of downloads without a Content-Length. Format is \"source=bytes;...\", e.g. \"rawspeed=65536;libraw=4096\".", func(s string) error {
+		for _, v := range strings.Split(s, ";") {
+			source, size, found := strings.Cut(v, "=")
+			_, ok := options.minSize[source]
+			if !found || !ok {
+				return fmt.Errorf("Invalid argument: \"%v\"\n", v)
+			}
+			i, err := strconv.Atoi(size)
+			if err != nil || i < 0 {
+				return fmt.Errorf("Size must be a positive integer: \"%v\"\n", v)
+			}
+			options.minSize[source] = i
+		}
+		return nil
+	})
+
+	flag.BoolVar(&freshFetch, "fresh", false, "Bypass HTTP caches when fetching sources, to get the latest upstream files.")
+	flag.Func("max-concurrency", "Maximum number of concurrent HTTP fetches. (default 4)", func(s string) error {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 {
+			return errors.New("Must be a positive integer\n")
+		}
+		fetchSlots = make(chan struct{}, i)
+		return nil
+	})
 
-	flag.Func("stats", "Print statistics. <stdout;table;text>", func(s string) error {
+	flag.Func("stats", "Print statistics. <stdout;table;text;json;formats;matrix;compact>", func(s string) error {
 		s = strings.ToLower(s)
 		for _, v := range strings.Split(s, ";") {
 			switch v {
@@ -130,6 +427,16 @@ func main() {
 				options.stats.table = true
 			case "text":
 				options.stats.text = true
+			case "json":
+				options.stats.json = true
+			case "formats":
+				options.stats.stdout = true
+				options.stats.formats = true
+			case "matrix":
+				options.stats.stdout = true
+				options.stats.matrix = true
+			case "compact":
+				options.stats.compact = true
 			default:
 				return fmt.Errorf("Invalid argument: \"%v\"\n", v)
 			}
@@ -137,13 +444,34 @@ func main() {
 		return nil
 	})
 
-	flag.Func("format", "Output format. <md|tsv|none>", func(s string) error {
-		m, err := regexp.MatchString(`^(md|tsv|none)$`, s)
+	flag.StringVar(&options.statsOutput, "stats-output", "", "Also write all statistics as JSON to this file, independent of -stats and the table output.")
+	flag.Func("stats-precision", "Number of decimal places for percentages in statistics.", func(s string) error {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 || i > 6 {
+			return errors.New("Must be an integer 0-6\n")
+		}
+		options.statsPrecision = i
+		return nil
+	})
+	flag.Func("rounding", "Rounding of percentages in statistics. <half-up|half-even|floor|ceil> (default half-up)", func(s string) error {
+		switch s {
+		case "half-up", "half-even", "floor", "ceil":
+			options.rounding = s
+		default:
+			return errors.New("Must be \"half-up\", \"half-even\", \"floor\" or \"ceil\"\n")
+		}
+		return nil
+	})
+
+	flag.BoolVar(&options.noColor, "no-color", false, "Don't color statistics printed to a terminal. Also disabled by the NO_COLOR environment variable.")
+
+	flag.Func("format", "Output format. <md|tsv|json|ndjson|none>", func(s string) error {
+		m, err := regexp.MatchString(`^(md|tsv|json|ndjson|none)$`, s)
 		if err != nil {
 			return err
 		}
 		if m != true {
-			return errors.New("Must be \"md\", \"tsv\" or \"none\"\n")
+			return errors.New("Must be \"md\", \"tsv\", \"json\", \"ndjson\" or \"none\"\n")
 		}
 		options.format = s
 		return nil
@@ -166,33 +494,59 @@ func main() {
 		return nil
 	})
 
-	flag.Func("fields", "Semicolon delimited list of fields to print. See the 'camera' struct in 'camera-support.go' for valid fields. <...|no-maker|all|all-debug>", func(s string) error {
-		switch s {
-		case "all":
-			options.fields = []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder", "rssupported", "formats"}
-		case "all-debug":
-			options.fields = []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder", "rssupported", "formats", "debug"}
-		case "no-maker":
-			options.fields = []string{"model", "aliases", "wbpresets", "noiseprofiles", "decoder"}
-		default:
-			options.fields = strings.Split(strings.ToLower(s), ";")
-
-			valid := true
-			badFields := []string{}
-			for _, f := range options.fields {
-				_, ok := columnHeaders[f]
-				if !ok {
-					badFields = append(badFields, f)
-					valid = false
-				}
-			}
-			if !valid {
-				return fmt.Errorf("One or more invalid field names: %q \n", badFields)
-			}
+	flag.Func("sort", "Field to sort rows by, instead of maker and model, or \"calibration\" for the least calibrated first. With -segments, rows are sorted within each segment.", func(s string) error {
+		s = strings.ToLower(s)
+		if _, ok := columnHeaders[s]; !ok && s != "calibration" {
+			return fmt.Errorf("Invalid field: \"%v\"\n", s)
+		}
+		options.sort = s
+		return nil
+	})
+	flag.Func("maker", "Semicolon delimited list of makers to output and count. Not case-sensitive.", func(s string) error {
+		options.makers = strings.Split(s, ";")
+		return nil
+	})
+	flag.BoolVar(&options.includeAliasesInSearch, "include-aliases-in-search", false, "With -maker, also output cameras with an alias starting with the maker.")
+	flag.Func("maker-order", "Semicolon delimited list of makers to output first, in this order. Other makers follow alphabetically.", func(s string) error {
+		options.makerOrder = strings.Split(s, ";")
+		return nil
+	})
+	flag.Func("maker-limit", "With -segments, only output the first N makers, or the last N if negative.", func(s string) error {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("Must be an integer\n")
+		}
+		options.makerLimit = i
+		return nil
+	})
+
+	flag.Func("lang", "Language of the column headers. <en|de|fr> (default en)", func(s string) error {
+		if _, err := translations.Open("translations/" + s + ".json"); err != nil && s != "en" {
+			return fmt.Errorf("Invalid argument: \"%v\"\n", s)
+		}
+		options.lang = s
+		return nil
+	})
+	fieldsArg := ""
+	flag.Func("fields", "Semicolon delimited list of fields to print. See the 'camera' struct in 'camera-support.go' for valid fields. <...|no-maker|all|all-debug|@preset>", func(s string) error {
+		fieldsArg = s
+		// Presets are expanded after parsing, since -presets may come later
+		if preset, found := strings.CutPrefix(s, "@"); found {
+			options.fieldsPreset = preset
+			return nil
+		}
+
+		fields, err := parseFields(s, columnHeaders)
+		if err != nil {
+			return err
 		}
+		options.fields = fields
+		options.fieldsPreset = ""
 		return nil
 	})
 
+	flag.StringVar(&options.presetsPath, "presets", "", "JSON file of named field lists, used with \"-fields @name\".")
+
 	flag.Func("bools", "Text to use for boolean fields. Format is \"true;false\" with a semicolon delimiter.", func(s string) error {
 		if strings.Count(s, ";") != 1 {
 			return errors.New("Must contain one semicolon\n")
@@ -202,9 +556,196 @@ func main() {
 	})
 
 	flag.BoolVar(&options.escape, "escape", false, "Escape Markdown characters in Model and Aliases fields.")
+	flag.Func("escape-mode", "Characters to escape with -escape. \"strict\" escapes all Markdown characters, \"github\" only those that break GitHub table cells. <strict|github>", func(s string) error {
+		if s != "strict" && s != "github" {
+			return errors.New("Must be \"strict\" or \"github\"\n")
+		}
+		options.escapeMode = s
+		return nil
+	})
+
+	flag.BoolVar(&options.explodeAliases, "explode-aliases", false, "Output each alias as its own row, with the decoder and flags of its parent model.")
+	flag.BoolVar(&options.footer, "footer", false, "Add a row with column totals. With -segments, also add one to each segment.")
+	flag.Func("decoder-labels", "Text to show for decoders in Markdown output, e.g. \"RawSpeed=⚡;LibRaw=📦;Unknown=❓\".", func(s string) error {
+		options.decoderLabels = map[string]string{}
+		for _, v := range strings.Split(s, ";") {
+			decoder, label, found := strings.Cut(v, "=")
+			if !found || decoder == "" {
+				return fmt.Errorf("Invalid argument: \"%v\"\n", v)
+			}
+			options.decoderLabels[strings.ToLower(decoder)] = label
+		}
+		return nil
+	})
+	flag.BoolVar(&options.decoderLabelsAll, "decoder-labels-all", false, "Also apply -decoder-labels to tsv and json output.")
+	flag.Func("wrap-aliases", "In Markdown output, start a new line in the Aliases cell after every N aliases.", func(s string) error {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 {
+			return errors.New("Must be a positive integer\n")
+		}
+		options.wrapAliases = i
+		return nil
+	})
+	flag.Func("legend", "Add a key explaining the -bools values and decoders in the Markdown table, before or after it. <before|after>", func(s string) error {
+		if s != "before" && s != "after" {
+			return errors.New("Must be \"before\" or \"after\"\n")
+		}
+		options.legend = s
+		return nil
+	})
+	flag.BoolVar(&options.hideDefaultFormats, "hide-default-formats", false, "Leave the Formats field empty for cameras that only have the default format.")
+	flag.StringVar(&options.emptyPlaceholder, "empty-placeholder", "", "Text to use for empty fields in Markdown and TSV output.")
+	flag.BoolVar(&options.nullEmpty, "null-empty", false, "Use null for empty strings and lists in JSON output.")
 	flag.BoolVar(&options.unknown, "unknown", false, "Include cameras with unknown support status. Also affects statistics.")
+	flag.BoolVar(&options.annotate, "annotate", false, "In tsv output, add a # comment line with the camera key and sources before each row.")
+	flag.BoolVar(&options.dropEmptyModel, "drop-empty-model", false, "Leave cameras with an empty model out of the output.")
 	flag.BoolVar(&options.unsupported, "unsupported", false, "Include unsupported cameras. Also affects statistics.")
-	flag.Parse()
+	flag.BoolVar(&options.partial, "partial", false, "Use the Partial decoder for cameras with a RawSpeed support note other than \"no\", instead of treating them as unsupported.")
+	flag.BoolVar(&options.countOnly, "count-only", false, "Only print the number of cameras, respecting -unknown and -unsupported.")
+	flag.BoolVar(&options.aboutList, "about-list", false, "Only output the sorted list of cameras as \"<Maker> <Model>\", one per line, e.g. for darktable's about dialog. Respects -unknown and -unsupported.")
+	flag.BoolVar(&options.aboutAliases, "about-aliases", false, "Append the aliases in parentheses to each camera in -about-list.")
+	flag.BoolVar(&options.modelsOnly, "models-only", false, "Only output the sorted list of models and aliases, one per line, respecting -unknown and -unsupported.")
+	flag.BoolVar(&options.listMakers, "list-makers", false, "Only print the makers and their number of cameras, respecting -unknown and -unsupported.")
+	flag.BoolVar(&options.mergeNearDuplicates, "merge-near-duplicates", false, "Merge cameras whose maker and model differ only in case or surrounding whitespace.")
+	flag.Func("fail-on", "Semicolon delimited list of warning categories that cause a failure. <"+strings.Join(sortedKeys(warningCounts), ";")+">", func(s string) error {
+		for _, v := range strings.Split(s, ";") {
+			if _, ok := warningCounts[v]; !ok {
+				return fmt.Errorf("Invalid warning category: \"%v\"\n", v)
+			}
+			options.failOn = append(options.failOn, v)
+		}
+		return nil
+	})
+
+	flag.Func("min-per-maker", "Fail if a maker has fewer models than expected, respecting -unknown and -unsupported. Format is \"maker=n;...\", can be repeated.", func(s string) error {
+		for _, v := range strings.Split(s, ";") {
+			maker, n, found := strings.Cut(v, "=")
+			i, err := strconv.Atoi(n)
+			if !found || maker == "" || err != nil || i < 0 {
+				return fmt.Errorf("Invalid argument: \"%v\"\n", v)
+			}
+			options.minPerMaker[maker] = i
+		}
+		return nil
+	})
+
+	flag.Func("max-rows-warn", "Warn if the output has more than N rows, e.g. from -explode-aliases or a bad merge.", func(s string) error {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 {
+			return errors.New("Must be a positive integer\n")
+		}
+		options.maxRowsWarn = i
+		return nil
+	})
+
+	flag.StringVar(&options.problems, "problems", "", "Write all warnings as a JSON list of {category, severity, maker, model, message} to this file.")
+
+	flag.BoolVar(&options.dumpMerged, "dump-merged", false, "Only print all merged camera data as JSON, with every field, for debugging.")
+
+	flag.Func("report", "Output a maintenance report instead of the camera table. <dng-orphans|libraw-dng-candidates|decoder-coverage|excluded>", func(s string) error {
+		switch s {
+		case "dng-orphans", "libraw-dng-candidates", "decoder-coverage", "excluded":
+			options.report = s
+		default:
+			return errors.New("Must be \"dng-orphans\", \"libraw-dng-candidates\", \"decoder-coverage\" or \"excluded\"\n")
+		}
+		return nil
+	})
+
+	flag.StringVar(&options.template, "template", "", "Render this Go text/template file with the cameras and stats instead of the table.")
+
+	flag.Func("split-by", "Write one file per group into the output directory, plus an 'index.md' linking them. <decoder|maker>", func(s string) error {
+		if s != "decoder" && s != "maker" {
+			return errors.New("Must be \"decoder\" or \"maker\"\n")
+		}
+		options.splitBy = s
+		return nil
+	})
+	flag.StringVar(&options.makerFilenamesPath, "maker-filenames", "", "JSON object of file names, without extension, for makers with -split-by maker. Other makers get a slug of their name.")
+
+	flag.BoolVar(&options.check, "check", false, "Fail if -segments headings or -manifest entries have empty or repeated anchors, which break links.")
+	flag.StringVar(&options.manifest, "manifest", "", "Write a JSON list of the output cameras with their anchors to this file.")
+	flag.StringVar(&options.makerCounts, "maker-counts", "", "Write a JSON object of the number of cameras per maker to this file, respecting -unknown and -unsupported.")
+	flag.StringVar(&options.summary, "summary", "", "Write a JSON summary of the run (counts, timing, sources) to this file.")
+	flag.Func("output-mode", "Octal permissions of created files, before the umask. (default 0666)", func(s string) error {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || mode > 0777 {
+			return errors.New("Must be octal permissions, e.g. 0644\n")
+		}
+		options.outputMode = os.FileMode(mode)
+		return nil
+	})
+	flag.StringVar(&options.cpuProfile, "cpuprofile", "", "Write a CPU profile of the run to this file.")
+	flag.StringVar(&options.memProfile, "memprofile", "", "Write a heap profile at the end of the run to this file.")
+	flag.BoolVar(&options.version, "version", false, "Print version information and exit.")
+	flag.CommandLine.Parse(expandArgsFiles(flag.CommandLine, os.Args[1:]))
+
+	if options.version == true {
+		printVersion()
+		return
+	}
+
+	// Not written if the run fails, since log.Fatal skips deferred calls
+	if options.cpuProfile != "" {
+		f, err := os.Create(options.cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if options.memProfile != "" {
+		defer writeMemProfile(options.memProfile)
+	}
+
+	// Only sources left at their default location are rewritten
+	if options.urlBase != "" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for name, p := range sourcePaths(&options) {
+			if !set[name] {
+				*p = strings.Replace(*p, "https://raw.githubusercontent.com", strings.TrimSuffix(options.urlBase, "/"), 1)
+			}
+		}
+	}
+
+	// Paths in a darktable source tree. rawspeed-dng.csv isn't part of it
+	if options.dataDir != "" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		for name, source := range map[string]struct {
+			p    *string
+			path string
+		}{
+			"rawspeed":      {&options.rawspeedPath, "src/external/rawspeed/data/cameras.xml"},
+			"libraw":        {&options.librawPath, "src/imageio/imageio_libraw.c"},
+			"wbpresets":     {&options.wbpresetsPath, "data/wb_presets.json"},
+			"noiseprofiles": {&options.noiseprofilesPath, "data/noiseprofiles.json"},
+		} {
+			if !set[name] {
+				*source.p = filepath.Join(options.dataDir, source.path)
+			}
+		}
+	}
+
+	if options.lang != "" && options.lang != "en" {
+		loadTranslation(columnHeaders, options.lang)
+	}
+
+	if options.readLock != "" {
+		readLock(&options)
+	}
+
+	if options.fieldsPreset != "" {
+		options.fields = loadFieldsPreset(options.fieldsPreset, columnHeaders, options)
+	}
+
+	// JSON is for tooling, so unless -fields lists the fields it has the raw and the normalized support status
+	if (options.format == "json" || options.format == "ndjson") && (fieldsArg == "" || fieldsArg == "all" || fieldsArg == "all-debug") {
+		options.fields = withSupportStatus(options.fields)
+	}
 
 	// Non-flag options
 	if flag.Arg(0) != "" {
@@ -213,519 +754,2471 @@ func main() {
 		options.output = "stdout"
 	}
 
-	//// Logic ////
+	if options.onlyNew == true && options.baseline == "" {
+		log.Fatal("-only-new requires -baseline")
+	}
 
-	cameras := map[string]camera{}
+	if options.splitBy != "" && (options.output == "stdout" || options.format == "none") {
+		log.Fatal("-split-by requires an output directory and a -format other than none")
+	}
 
-	loadRawSpeed(cameras, options)
+	//// Logic ////
 
-	if options.librawPath != "" {
-		loadLibRaw(cameras, options)
+	if options.oldWBPresetsPath != "" || options.oldNoiseProfilesPath != "" {
+		writeOutput(options, func(w io.Writer) {
+			generateCalibrationDiff(w, options)
+		})
+		return
 	}
 
-	loadWBPresets(cameras, options)
-	loadNoiseProfiles(cameras, options)
+	start := time.Now()
+	cameras := map[string]camera{}
+	dngOrphans := []dngCamera{}
 
-	loadRawSpeedDNG(cameras, options)
+	if options.loadMerged != "" {
+		cameras = loadMerged(options.loadMerged)
+	} else {
+		supportedModes := loadRawSpeed(cameras, options)
 
-	stats := generateStats(cameras, options)
+		if options.librawPath != "" {
+			loadLibRaw(cameras, options)
+		}
+		checkFormatsDecoder(cameras, supportedModes)
 
-	////  Output  ////
+		loadWBPresets(cameras, options)
+		loadNoiseProfiles(cameras, options)
+		if options.noiseprofilesExtraPath != "" {
+			loadNoiseProfilesExtra(cameras, options)
+		}
 
-	if options.format != "none" {
-		data := prepareOutputData(cameras, options)
+		dngOrphans = loadRawSpeedDNG(cameras, options)
 
-		outputString := ""
-		switch options.format {
-		case "md":
-			outputString = generateMD(data, columnHeaders, stats, options)
-		case "tsv":
-			outputString = generateTSV(data, columnHeaders, options)
+		if options.makerCanonicalPath != "" {
+			canonicalizeMakers(cameras, options)
 		}
 
-		if options.output != "stdout" {
-			if err := os.WriteFile(options.output, []byte(outputString), 0666); err != nil {
-				log.Fatal(err)
-			}
-		} else {
-			fmt.Print(outputString)
-		}
-	}
+		bucketEmptyMakers(cameras)
+		findNearDuplicates(cameras, options)
+		checkAliasCalibration(cameras)
 
-	if options.stats.stdout == true {
-		if options.output == "stdout" && options.format != "none" {
-			fmt.Println("")
+		if options.equivalentsPath != "" {
+			mergeEquivalents(cameras, options)
 		}
-		fmt.Printf("Cameras:\t %4v\n", stats.cameras)
-		fmt.Printf("  RawSpeed:\t %4v  %3v%%\n", stats.rawspeed, stats.rawspeedPercent)
-		fmt.Printf("  LibRaw:\t %4v  %3v%%\n", stats.libraw, stats.librawPercent)
-		if options.unknown == true || options.unsupported == true {
-			fmt.Printf("  Supported:\t %4v  %3v%%\n", stats.supported, stats.supportedPercent)
-		}
-		if options.unknown == true {
-			fmt.Printf("  Unknown:\t %4v  %3v%%\n", stats.unknown, stats.unknownPercent)
+
+		if options.annotationsPath != "" {
+			loadAnnotations(cameras, options)
 		}
-		if options.unsupported == true {
-			fmt.Printf("  Unsupported:\t %4v  %3v%%\n", stats.unsupported, stats.unsupportedPercent)
+
+		if options.aliasesExtraPath != "" {
+			loadAliasesExtra(cameras, options)
 		}
-		fmt.Printf("Aliases:\t %4v\n", stats.aliases)
-		fmt.Printf("WB Presets:\t %4v  %3v%%\n", stats.wbPresets, stats.wbPresetsPercent)
-		fmt.Printf("Noise Profiles:\t %4v  %3v%%\n", stats.noiseProfiles, stats.noiseProfilePercent)
 	}
-}
 
-func getData(path string) []byte {
-	if strings.HasPrefix(path, "https://") {
-		client := &http.Client{
-			Timeout: 30 * time.Second,
-		}
+	checkCalibrationDecoder(cameras)
+	checkSharedAliases(cameras)
+	checkSharedModels(cameras)
 
-		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, path, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if options.writeLock != "" {
+		writeLock(options)
+	}
 
-		res, err := client.Do(req)
-		if err != nil {
-			log.Fatal(err)
+	if options.saveMerged != "" {
+		saveMerged(cameras, options)
+	}
+
+	if options.baseline != "" {
+		baseline := loadMerged(options.baseline)
+		for k, c := range cameras {
+			if _, ok := baseline[k]; !ok {
+				c.IsNew = true
+				cameras[k] = c
+			} else if options.onlyNew == true {
+				delete(cameras, k)
+			}
 		}
+	}
 
-		data, err := io.ReadAll(res.Body)
-		res.Body.Close()
-		if res.StatusCode > 299 {
-			log.Fatalf("Response failed with status code %d and\nbody: %s\n", res.StatusCode, data)
+	if options.includePath != "" {
+		keepIncluded(cameras, options)
+	}
+
+	if options.formatFilter != nil {
+		keepFormats(cameras, options)
+	}
+
+	if len(options.makers) > 0 {
+		for k, c := range cameras {
+			if !matchesMaker(c, options) {
+				delete(cameras, k)
+			}
 		}
-		if err != nil {
-			log.Fatal(err)
+	}
+
+	if options.maxRowsWarn != 0 {
+		if keys, _ := selectOutputCameras(cameras, options); len(keys) > options.maxRowsWarn {
+			warnf("max-rows", "", "", "Output has %v rows, more than the -max-rows-warn limit of %v", len(keys), options.maxRowsWarn)
 		}
-		return data
-	} else {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			log.Fatal(err)
+	}
+
+	if options.problems != "" {
+		writeProblems(options)
+	}
+
+	for _, category := range options.failOn {
+		if warningCounts[category] > 0 {
+			log.Fatalf("Failed due to %v warning(s) in category %v\n", warningCounts[category], category)
 		}
-		return data
 	}
-}
 
-func loadRawSpeed(cameras map[string]camera, options options) {
-	camerasXML := etree.NewDocument()
-	if err := camerasXML.ReadFromBytes(getData(options.rawspeedPath)); err != nil {
-		log.Fatal(err)
+	if len(options.minPerMaker) > 0 {
+		checkMinPerMaker(cameras, options)
 	}
 
-	root := camerasXML.SelectElement("Cameras")
-	for _, c := range root.SelectElements("Camera") {
-		maker := ""
-		model := ""
-		debug := make([]string, 0, 3)
-		key := ""
+	stats := generateStats(cameras, options)
 
-		if id := c.SelectElement("ID"); id != nil {
-			maker = id.SelectAttrValue("make", "")
-			model = id.SelectAttrValue("model", "")
-			key = cameraKey(maker, model)
-		} else { // No <ID> element so get from <Camera>
-			maker = c.SelectAttrValue("make", "")
-			model = c.SelectAttrValue("model", "")
-			key = cameraKey(maker, model)
+	if options.countOnly == true {
+		fmt.Println(stats.cameras)
+		return
+	}
 
-			if model == "" {
-				debug = append(debug, "cameras.xml: No Model in Camera element")
+	if options.listMakers == true {
+		listMakers(cameras, options)
+		return
+	}
+
+	if options.modelsOnly == true {
+		writeOutput(options, func(w io.Writer) {
+			listModels(w, cameras, options)
+		})
+		return
+	}
+
+	if options.aboutList == true {
+		writeOutput(options, func(w io.Writer) {
+			listAbout(w, cameras, options)
+		})
+		return
+	}
+
+	if options.dumpMerged == true {
+		os.Stdout.Write(marshalMerged(cameras))
+		return
+	}
+
+	if options.check == true {
+		if problems := checkAnchors(cameras, options); len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintln(os.Stderr, p)
 			}
+			log.Fatalf("Failed due to %v anchor problem(s)\n", len(problems))
 		}
+	}
 
-		camera := cameras[key]
-		camera.Maker = maker
-		camera.Model = model
-
-		if aliases := c.SelectElement("Aliases"); aliases != nil {
-			for _, a := range aliases.SelectElements("Alias") {
-				alias := ""
-				id := a.SelectAttrValue("id", "")
-				val := a.Text()
+	////  Output  ////
 
-				// Sometimes <Alias> doesn't have an id attribute, so use the text instead
-				// Would be better if cameras.xml was consistent
-				if id == "" {
-					alias, _ = strings.CutPrefix(val, maker+" ")
-					debug = append(debug, "cameras.xml: No id in Alias")
-				} else {
-					alias = id
+	if options.report != "" {
+		headers, rows := []string{}, [][]string{}
+		switch options.report {
+		case "dng-orphans":
+			headers = []string{"Maker", "Model"}
+			for _, c := range dngOrphans {
+				rows = append(rows, []string{c.Maker, c.Model})
+			}
+		case "libraw-dng-candidates":
+			// LibRaw cameras that RawSpeed could decode through the DNG path instead
+			headers = []string{"Maker", "Model"}
+			for _, k := range sortedKeys(cameras) {
+				c := cameras[k]
+				if slices.Contains(c.Sources, "libraw") && slices.Contains(c.Sources, "rawspeeddng") {
+					rows = append(rows, []string{c.Maker, c.Model})
 				}
-
-				camera.Aliases = append(camera.Aliases, alias)
+			}
+		case "decoder-coverage":
+			// A camera has a single Decoder, so coverage is taken from the sources listing it
+			rawspeedOnly, librawOnly, both := 0, 0, 0
+			for _, c := range cameras {
+				rawspeed := (slices.Contains(c.Sources, "rawspeed") && c.RSSupported == "") || slices.Contains(c.Sources, "rawspeeddng")
+				libraw := slices.Contains(c.Sources, "libraw")
+				switch {
+				case rawspeed && libraw:
+					both++
+				case rawspeed:
+					rawspeedOnly++
+				case libraw:
+					librawOnly++
+				}
+			}
+			headers = []string{"Coverage", "Cameras"}
+			rows = [][]string{
+				{"RawSpeed only", fmt.Sprint(rawspeedOnly)},
+				{"LibRaw only", fmt.Sprint(librawOnly)},
+				{"Both", fmt.Sprint(both)},
+			}
+		case "excluded":
+			// Cameras without a decoder, which the table leaves out unless -unsupported is used
+			headers = []string{"Maker", "Model", "Reason"}
+			for _, k := range sortedKeys(cameras) {
+				c := cameras[k]
+				if c.Decoder != "" {
+					continue
+				}
+				reason := fmt.Sprintf("cameras.xml: supported=%q", c.RSSupported)
+				if c.RSSupported != "no" && options.partial == false {
+					reason += ", would be Partial with -partial"
+				}
+				rows = append(rows, []string{c.Maker, c.Model, reason})
 			}
 		}
 
-		if format := c.SelectAttrValue("mode", ""); format != "" {
-			camera.Formats = append(camera.Formats, format)
-		} else {
-			camera.Formats = append(camera.Formats, "default")
+		if options.format != "none" {
+			writeOutput(options, func(w io.Writer) {
+				generateReport(w, headers, rows, options)
+			})
 		}
+		return
+	}
 
-		camera.RSSupported = c.SelectAttrValue("supported", "")
-		if camera.RSSupported == "" {
-			camera.Decoder = "RawSpeed"
+	if options.template != "" {
+		writeOutput(options, func(w io.Writer) {
+			generateTemplate(w, cameras, stats, options)
+		})
+	} else if options.splitBy != "" {
+		writeSplit(cameras, columnHeaders, options)
+	} else {
+		writeTable(cameras, columnHeaders, stats, options)
+	}
+
+	if options.stats.stdout == true {
+		if options.output == "stdout" && options.format != "none" {
+			fmt.Println("")
 		}
+		printStats(stats, options)
+	}
 
-		slices.Sort(camera.Aliases)
-		slices.Reverse(camera.Aliases) // Ensure ALL CAPS aliases get removed by slices.CompactFunc
-		camera.Aliases = slices.CompactFunc(camera.Aliases, strings.EqualFold)
-		slices.Reverse(camera.Aliases)
+	if options.stats.compact == true {
+		if options.output == "stdout" && (options.format != "none" || options.stats.stdout == true) {
+			fmt.Println("")
+		}
+		printCompactStats(stats, options)
+	}
 
-		camera.Debug = append(camera.Debug, debug...)
-		cameras[key] = camera
+	// With no table, JSON stats take its place in the output file
+	if options.stats.json == true {
+		if options.format == "none" && options.output != "stdout" {
+			writeOutput(options, func(w io.Writer) {
+				generateStatsJSON(w, stats, options)
+			})
+		} else {
+			if options.output == "stdout" && (options.format != "none" || options.stats.stdout == true || options.stats.compact == true) {
+				fmt.Println("")
+			}
+			generateStatsJSON(os.Stdout, stats, options)
+		}
 	}
-}
 
-func loadLibRaw(cameras map[string]camera, options options) {
-	inStruct := false
-	maker := ""
-	model := ""
-	alias := ""
+	// Every stat, so automation doesn't depend on the -stats flags of the run
+	if options.statsOutput != "" {
+		statsOptions := options
+		statsOptions.output = options.statsOutput
+		statsOptions.stats.formats = true
+		statsOptions.stats.matrix = true
+		writeOutput(statsOptions, func(w io.Writer) {
+			generateStatsJSON(w, stats, statsOptions)
+		})
+	}
 
-	librawData := string(getData(options.librawPath))
-	scanner := bufio.NewScanner(strings.NewReader(librawData))
-	for scanner.Scan() {
-		line := scanner.Text()
+	if options.manifest != "" {
+		writeManifest(cameras, options)
+	}
 
-		if strings.Contains(line, "const model_map_t modelMap[] = {") {
-			inStruct = true
-			continue
-		} else if inStruct == false {
+	if options.makerCounts != "" {
+		writeMakerCounts(cameras, options)
+	}
+
+	if options.summary != "" {
+		writeSummary(start, stats, options)
+	}
+}
+
+// Replaces @path arguments with the arguments in the file, one per line.
+// Lines are used as is, without shell quoting. Empty lines and lines starting with # are skipped.
+// An @ argument that is the value of the previous flag, e.g. -fields @preset, is kept
+func expandArgsFiles(flags *flag.FlagSet, args []string) []string {
+	expanded := make([]string, 0, len(args))
+	for i, a := range args {
+		path, ok := strings.CutPrefix(a, "@")
+		if !ok || path == "" || (i > 0 && takesValue(flags, args[i-1])) {
+			expanded = append(expanded, a)
 			continue
-		} else if strings.Contains(line, "};") && inStruct == true {
-			break
 		}
 
-		re := regexp.MustCompile(`".+"`)
-		foundStr := strings.Trim(re.FindString(line), "\"")
-		if strings.Contains(line, ".clean_make =") {
-			maker = foundStr
-		} else if strings.Contains(line, ".clean_model =") {
-			model = foundStr
-		} else if strings.Contains(line, ".clean_alias =") {
-			alias = foundStr
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal("Cannot read arguments file: ", err)
 		}
-
-		if strings.Contains(line, "},") {
-			key := cameraKey(maker, model)
-			camera := cameras[key]
-
-			if model != alias {
-				camera.Aliases = append(camera.Aliases, alias)
-				slices.Sort(camera.Aliases)
-				slices.Reverse(camera.Aliases) // Ensure ALL CAPS aliases get removed by slices.CompactFunc
-				camera.Aliases = slices.CompactFunc(camera.Aliases, strings.EqualFold)
-				slices.Reverse(camera.Aliases)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
 			}
+			expanded = append(expanded, line)
+		}
+	}
+	return expanded
+}
 
-			camera.Maker = maker
-			camera.Model = model
-			camera.Decoder = "LibRaw"
-			cameras[key] = camera
+// Whether arg is a flag without =value whose value is the next argument
+func takesValue(flags *flag.FlagSet, arg string) bool {
+	name, ok := strings.CutPrefix(arg, "-")
+	if !ok || strings.Contains(name, "=") {
+		return false
+	}
+	f := flags.Lookup(strings.TrimPrefix(name, "-"))
+	if f == nil {
+		return false
+	}
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		return false
+	}
+	return true
+}
+
+// Replaces the headers present in the translation. Missing ones stay English
+func loadTranslation(colHeaders map[string]string, lang string) {
+	data, err := translations.ReadFile("translations/" + lang + ".json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var headers map[string]string
+	if err := json.Unmarshal(data, &headers); err != nil {
+		log.Fatalf("Unable to unmarshal translation %v: %v", lang, err)
+	}
+	for k, v := range headers {
+		if _, ok := colHeaders[k]; ok && v != "" {
+			colHeaders[k] = v
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Error reading imageio_libraw.c: ", err)
+}
+
+// Location of each source, by option name
+func sourcePaths(options *options) map[string]*string {
+	return map[string]*string{
+		"rawspeed":      &options.rawspeedPath,
+		"rawspeeddng":   &options.rawspeedDNGPath,
+		"libraw":        &options.librawPath,
+		"wbpresets":     &options.wbpresetsPath,
+		"noiseprofiles": &options.noiseprofilesPath,
 	}
+}
 
-	if inStruct == false {
-		log.Fatal("No LibRaw cameras found in ", options.librawPath)
+// Splits a raw.githubusercontent.com URL into the repository and the ref, e.g. a branch.
+// Refs containing a slash aren't supported
+func splitRawURL(url string) (repo string, ref string, ok bool) {
+	rest, found := strings.CutPrefix(url, "https://raw.githubusercontent.com/")
+	parts := strings.SplitN(rest, "/", 4)
+	if !found || len(parts) < 4 {
+		return "", "", false
 	}
+	return parts[0] + "/" + parts[1], parts[2], true
 }
 
-func loadWBPresets(cameras map[string]camera, options options) {
-	type Presets struct {
-		WBPresets []struct {
-			Maker  string `json:"maker"`
-			Models []struct {
-				Model string `json:"model"`
-			} `json:"models"`
-		} `json:"wb_presets"`
+// Commit the ref of a GitHub repository currently points to
+func resolveCommit(repo string, ref string) string {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "https://api.github.com/repos/"+repo+"/commits/"+ref, nil)
+	if err != nil {
+		log.Fatal(err)
 	}
+	req.Header.Set("Accept", "application/vnd.github.sha")
 
-	var presets Presets
-	err := json.Unmarshal(getData(options.wbpresetsPath), &presets)
+	fetchSlots <- struct{}{}
+	res, err := httpClient.Do(req)
+	<-fetchSlots
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
 	if err != nil {
-		log.Fatal("Unable to unmarshal wb_presets.json: ", err)
+		log.Fatal(err)
 	}
+	if res.StatusCode > 299 {
+		log.Fatalf("Unable to resolve %v of %v: status code %d\nbody: %s\n", ref, repo, res.StatusCode, data)
+	}
+	return strings.TrimSpace(string(data))
+}
 
-	for _, v := range presets.WBPresets {
-		for _, m := range v.Models {
-			key := cameraKey(v.Maker, m.Model)
-			camera := cameras[key]
-			if camera.Maker == "" { // Camera isn't present in cameras.xml or imageio_libraw.c
-				camera.Decoder = "Unknown"
-				camera.Debug = append(camera.Debug, "Source: wb_presets.json")
-			} else if camera.Decoder == "" {
-				camera.Debug = append(camera.Debug, "wb_presets.json: No decoder")
+// Only fetched sources are recorded. Those on GitHub also get the commit their ref resolved to
+func writeLock(options options) {
+	entries := []lockEntry{}
+	paths := sourcePaths(&options)
+	for _, name := range sortedKeys(paths) {
+		for _, s := range sourceLog {
+			if s.Path != strings.SplitN(*paths[name], "#", 2)[0] || s.Origin != "fetched" {
+				continue
 			}
-			camera.Maker = v.Maker
-			camera.Model = m.Model
-			camera.WBPresets = true
-			cameras[key] = camera
+			entry := lockEntry{Source: name, URL: *paths[name], ETag: s.ETag}
+			if pinned, ok := pinnedSources[name]; ok {
+				entry.URL, entry.Ref, entry.Commit = pinned.URL, pinned.Ref, pinned.Commit
+			} else if repo, ref, ok := splitRawURL(entry.URL); ok {
+				entry.Ref = ref
+				entry.Commit = resolveCommit(repo, ref)
+			}
+			entries = append(entries, entry)
+			break
 		}
 	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(options.writeLock, append(data, '\n'), options.outputMode); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func loadNoiseProfiles(cameras map[string]camera, options options) {
-	type Profiles struct {
-		Noiseprofiles []struct {
-			Maker  string `json:"maker"`
-			Models []struct {
-				Model string `json:"model"`
-			} `json:"models"`
-		} `json:"noiseprofiles"`
+// Rewrites the ref of each locked source at its recorded location to the commit.
+// Warns if the ref has moved on since
+func readLock(options *options) {
+	data, err := os.ReadFile(options.readLock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var entries []lockEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Fatalf("Unable to unmarshal %v: %v", options.readLock, err)
+	}
+
+	paths := sourcePaths(options)
+	for _, e := range entries {
+		p, ok := paths[e.Source]
+		if !ok || *p != e.URL || e.Commit == "" {
+			continue
+		}
+		repo, ref, ok := splitRawURL(e.URL)
+		if !ok || ref != e.Ref {
+			continue
+		}
+		*p = strings.Replace(e.URL, "/"+repo+"/"+ref+"/", "/"+repo+"/"+e.Commit+"/", 1)
+		pinnedSources[e.Source] = e
+
+		if current := resolveCommit(repo, ref); current != e.Commit {
+			warnf("lock-outdated", "", "", "%v: %v %v has moved from %v to %v", e.Source, repo, ref, e.Commit, current)
+		}
 	}
+}
 
-	var profiles Profiles
-	err := json.Unmarshal(getData(options.noiseprofilesPath), &profiles)
+func writeMemProfile(path string) {
+	f, err := os.Create(path)
 	if err != nil {
-		log.Fatal("Unable to unmarshal noiseprofiles.json: ", err)
+		log.Fatal(err)
+	}
+	defer f.Close()
+	runtime.GC() // Up to date statistics
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	for _, v := range profiles.Noiseprofiles {
-		for _, m := range v.Models {
-			key := cameraKey(v.Maker, m.Model)
-			camera := cameras[key]
-			if camera.Maker == "" { // Camera isn't present in cameras.xml or imageio_libraw.c
-				camera.Decoder = "Unknown"
-				camera.Debug = append(camera.Debug, "Source: noiseprofiles.json")
-			} else if camera.Decoder == "" {
-				camera.Debug = append(camera.Debug, "noiseprofiles.json: No decoder")
+func printVersion() {
+	// Fall back to the VCS information Go embeds when building from a git checkout
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && commit == "" {
+				commit = s.Value
+			} else if s.Key == "vcs.time" && buildDate == "" {
+				buildDate = s.Value
 			}
-			camera.Maker = v.Maker
-			camera.Model = m.Model
-			camera.NoiseProfiles = true
-			cameras[key] = camera
 		}
 	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+
+	fmt.Printf("camera-support %v\ncommit: %v\nbuilt: %v\n", version, commit, buildDate)
+}
+
+// Adds rssupported and supportstatus after the decoder, if missing
+func withSupportStatus(fields []string) []string {
+	i := slices.Index(fields, "decoder") + 1
+	for _, f := range []string{"rssupported", "supportstatus"} {
+		if !slices.Contains(fields, f) {
+			fields = slices.Insert(slices.Clone(fields), i, f)
+		}
+		i = slices.Index(fields, f) + 1
+	}
+	return fields
 }
 
-func loadRawSpeedDNG(cameras map[string]camera, options options) {
-	reader := csv.NewReader(strings.NewReader(string(getData(options.rawspeedDNGPath))))
+func parseFields(s string, colHeaders map[string]string) ([]string, error) {
+	switch s {
+	case "all":
+		return []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder", "rssupported", "formats", "hints"}, nil
+	case "all-debug":
+		return []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder", "rssupported", "formats", "hints", "sources", "debug"}, nil
+	case "no-maker":
+		return []string{"model", "aliases", "wbpresets", "noiseprofiles", "decoder"}, nil
+	}
 
-	for {
-		c, err := reader.Read()
-		if err == io.EOF {
-			break
+	fields := strings.Split(strings.ToLower(s), ";")
+
+	badFields := []string{}
+	for _, f := range fields {
+		if _, ok := colHeaders[f]; !ok {
+			badFields = append(badFields, f)
+		}
+	}
+	if len(badFields) > 0 {
+		return nil, fmt.Errorf("One or more invalid field names: %q \n", badFields)
+	}
+	return fields, nil
+}
+
+// Presets file is a JSON object of preset names to field lists, e.g. {"website": "model;aliases;decoder"}
+func loadFieldsPreset(name string, colHeaders map[string]string, options options) []string {
+	if options.presetsPath == "" {
+		log.Fatalf("-fields @%v needs a presets file, see -presets\n", name)
+	}
+
+	presets := map[string]string{}
+	if err := json.Unmarshal(getData(options.presetsPath, 0), &presets); err != nil {
+		log.Fatal("Unable to unmarshal presets file: ", err)
+	}
+
+	preset, ok := presets[name]
+	if !ok {
+		log.Fatalf("Preset \"%v\" not found in %v\n", name, options.presetsPath)
+	}
+
+	fields, err := parseFields(preset, colHeaders)
+	if err != nil {
+		log.Fatalf("Preset \"%v\": %v", name, err)
+	}
+	return fields
+}
+
+// Prints a warning to stderr as "WARN[category] message", and counts it for -fail-on and -summary
+// maker and model are those of the camera the warning is about, and are recorded for -problems
+func warnf(category string, maker string, model string, format string, args ...any) {
+	if _, ok := warningCounts[category]; !ok {
+		log.Fatalf("Unknown warning category: %v\n", category)
+	}
+	message := fmt.Sprintf(format, args...)
+	fmt.Fprintf(os.Stderr, "WARN[%v] %v\n", category, message)
+	warningCounts[category] += 1
+	problems = append(problems, problem{Category: category, Maker: maker, Model: model, Message: message})
+}
+
+// Severity is "error" for categories in -fail-on, otherwise "warning"
+func writeProblems(options options) {
+	for i, p := range problems {
+		problems[i].Severity = "warning"
+		if slices.Contains(options.failOn, p.Category) {
+			problems[i].Severity = "error"
+		}
+	}
+
+	data, err := json.MarshalIndent(problems, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(options.problems, append(data, '\n'), options.outputMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Path may point into an archive with a "#member" suffix, e.g. "darktable.tar.gz#data/cameras.xml"
+func getData(path string, minSize int) []byte {
+	data := []byte{}
+	path, member, inArchive := strings.Cut(path, "#")
+
+	if strings.HasPrefix(path, "https://") {
+		url := path
+		if freshFetch == true {
+			// A unique query string makes CDNs treat it as a new resource
+			sep := "?"
+			if strings.Contains(url, "?") {
+				sep = "&"
+			}
+			url += fmt.Sprintf("%vnocache=%v", sep, time.Now().UnixNano())
 		}
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
 		if err != nil {
-			log.Fatal("Cannot read rawspeed-dng.csv: ", err)
+			log.Fatal(err)
+		}
+		if freshFetch == true {
+			req.Header.Set("Cache-Control", "no-cache")
 		}
 
-		maker := c[0]
-		model := c[1]
-		key := cameraKey(maker, model)
+		fetchSlots <- struct{}{}
+		var res *http.Response
+		for attempt := 0; ; attempt++ {
+			res, err = httpClient.Do(req)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if res.StatusCode != http.StatusTooManyRequests || attempt == maxFetchRetries {
+				break
+			}
+			res.Body.Close()
+			wait := retryAfter(res.Header.Get("Retry-After"))
+			fmt.Fprintf(os.Stderr, "Rate limited fetching %v, retrying in %v\n", path, wait)
+			time.Sleep(wait)
+		}
 
-		if maker == "Maker" && model == "Model" {
-			continue
+		data, err = io.ReadAll(res.Body)
+		res.Body.Close()
+		<-fetchSlots
+		if res.StatusCode > 299 {
+			log.Fatalf("Response failed with status code %d and\nbody: %s\n", res.StatusCode, data)
+		}
+		// A dropped connection leaves us with a partial file, which could still parse
+		if errors.Is(err, io.ErrUnexpectedEOF) && res.ContentLength >= 0 {
+			log.Fatalf("Incomplete download of %v: expected %d bytes, got %d\n", path, res.ContentLength, len(data))
+		}
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		camera, ok := cameras[key]
-		if ok {
-			camera.Decoder = "RawSpeed"
-			camera.Debug = append(camera.Debug, "rawspeed-dng: Decoder set")
-			cameras[key] = camera
-		} else {
-			log.Fatalln("rawspeed-dng.csv:", maker, model, "not found in cameras")
+		// Without a Content-Length, only the size hints at a partial file
+		if res.ContentLength < 0 && len(data) < minSize {
+			log.Fatalf("Download of %v is %d bytes, expected at least %d. See -min-size\n", path, len(data), minSize)
+		}
+
+		sourceLog = append(sourceLog, sourceInfo{Path: path, Origin: "fetched", ETag: res.Header.Get("ETag"), Bytes: len(data)})
+	} else {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
 		}
 
+		sourceLog = append(sourceLog, sourceInfo{Path: path, Origin: "local", Bytes: len(data)})
 	}
+
+	if inArchive {
+		data = extractArchiveMember(path, member, data)
+	}
+
+	// Files edited on Windows sometimes start with a UTF-8 BOM, which breaks parsing
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
 }
 
-func generateStats(cameras map[string]camera, options options) stats {
+// Delay from a Retry-After header, in seconds or as an HTTP date. Capped at a minute
+func retryAfter(header string) time.Duration {
+	wait := 5 * time.Second
+	if s, err := strconv.Atoi(header); err == nil && s >= 0 {
+		wait = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		wait = max(time.Until(t), 0)
+	}
+	return min(wait, time.Minute)
+}
 
-	s := stats{}
+// Supports .zip, .tar, .tar.gz and .tgz, detected by extension
+func extractArchiveMember(archivePath string, member string, data []byte) []byte {
+	archiveName := strings.ToLower(archivePath)
+	member = path.Clean(strings.TrimPrefix(member, "./"))
 
-	for _, c := range cameras {
-		if c.Decoder == "" && options.unsupported == false {
-			continue
-		} else if c.Decoder == "" && options.unsupported == true {
-			s.unsupported += 1
-		} else if c.Decoder == "Unknown" && options.unknown == false {
-			continue
-		} else if c.Decoder == "Unknown" && options.unknown == true {
-			s.unknown += 1
-		} else if c.Decoder == "RawSpeed" {
-			s.rawspeed += 1
-			s.supported += 1
-		} else if c.Decoder == "LibRaw" {
-			s.libraw += 1
-			s.supported += 1
+	switch {
+	case strings.HasSuffix(archiveName, ".zip"):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			log.Fatalf("Cannot read %v: %v\n", archivePath, err)
+		}
+		f, err := zr.Open(member)
+		if err != nil {
+			log.Fatalf("Cannot open %v in %v: %v\n", member, archivePath, err)
+		}
+		defer f.Close()
+		memberData, err := io.ReadAll(f)
+		if err != nil {
+			log.Fatalf("Cannot read %v in %v: %v\n", member, archivePath, err)
+		}
+		return memberData
+
+	case strings.HasSuffix(archiveName, ".tar"), strings.HasSuffix(archiveName, ".tar.gz"), strings.HasSuffix(archiveName, ".tgz"):
+		var r io.Reader = bytes.NewReader(data)
+		if !strings.HasSuffix(archiveName, ".tar") {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				log.Fatalf("Cannot read %v: %v\n", archivePath, err)
+			}
+			defer gz.Close()
+			r = gz
 		}
 
-		s.aliases += len(c.Aliases)
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Fatalf("Cannot read %v: %v\n", archivePath, err)
+			}
+			if path.Clean(strings.TrimPrefix(hdr.Name, "./")) != member {
+				continue
+			}
+			memberData, err := io.ReadAll(tr)
+			if err != nil {
+				log.Fatalf("Cannot read %v in %v: %v\n", member, archivePath, err)
+			}
+			return memberData
+		}
+		log.Fatalf("%v not found in %v\n", member, archivePath)
+
+	default:
+		log.Fatalf("Unsupported archive type: %v\n", archivePath)
+	}
+	return nil
+}
+
+// Returns the modes of <Camera> elements not marked supported="no", by camera key
+func loadRawSpeed(cameras map[string]camera, options options) map[string][]string {
+	camerasXML := etree.NewDocument()
+	if err := camerasXML.ReadFromBytes(getData(options.rawspeedPath, options.minSize["rawspeed"])); err != nil {
+		log.Fatal(err)
+	}
+
+	// Tags without a prefix match any namespace, and processing instructions and comments are skipped
+	root := camerasXML.SelectElement("Cameras")
+	if root == nil {
+		log.Fatal("No <Cameras> root element in ", options.rawspeedPath)
+	}
+	if options.strictSchema == true {
+		if err := checkCamerasXML(root); err != nil {
+			log.Fatal("cameras.xml doesn't match the expected structure: ", err)
+		}
+	}
+	supportedModes := map[string][]string{}
+	for _, c := range root.SelectElements("Camera") {
+		maker := ""
+		model := ""
+		debug := make([]string, 0, 3)
+		key := ""
+
+		if id := c.SelectElement("ID"); id != nil {
+			maker = id.SelectAttrValue("make", "")
+			model = id.SelectAttrValue("model", "")
+			key = cameraKey(maker, model)
+		} else { // No <ID> element so get from <Camera>
+			maker = c.SelectAttrValue("make", "")
+			model = c.SelectAttrValue("model", "")
+			key = cameraKey(maker, model)
+
+			if model == "" {
+				debug = append(debug, "cameras.xml: No Model in Camera element")
+			}
+		}
+
+		camera := cameras[key]
+		camera.Maker = maker
+		camera.Model = model
+
+		if aliases := c.SelectElement("Aliases"); aliases != nil {
+			for _, a := range aliases.SelectElements("Alias") {
+				alias := ""
+				id := a.SelectAttrValue("id", "")
+				val := a.Text()
+
+				// Sometimes <Alias> doesn't have an id attribute, so use the text instead
+				// Would be better if cameras.xml was consistent
+				if id == "" {
+					// The text can start with the maker from <ID> or from <Camera>, which may differ
+					alias = stripMakerPrefix(val, maker, c.SelectAttrValue("make", ""))
+					debug = append(debug, "cameras.xml: No id in Alias")
+				} else {
+					alias = id
+				}
+
+				camera.Aliases = append(camera.Aliases, alias)
+			}
+		}
+
+		if hints := c.SelectElement("Hints"); hints != nil {
+			for _, h := range hints.SelectElements("Hint") {
+				if name := h.SelectAttrValue("name", ""); name != "" {
+					camera.Hints = append(camera.Hints, name)
+				}
+			}
+			slices.Sort(camera.Hints)
+			camera.Hints = slices.Compact(camera.Hints)
+		}
+
+		if format := c.SelectAttrValue("mode", ""); format != "" {
+			camera.Formats = append(camera.Formats, format)
+		} else {
+			camera.Formats = append(camera.Formats, "default")
+		}
+
+		camera.RSSupported = c.SelectAttrValue("supported", "")
+		switch camera.RSSupported {
+		case "":
+			camera.SupportStatus = "true"
+		case "no":
+			camera.SupportStatus = "false"
+		default: // e.g. no-samples
+			camera.SupportStatus = "partial"
+		}
+		if format := c.SelectAttrValue("mode", ""); format != "" && camera.RSSupported != "no" {
+			supportedModes[key] = append(supportedModes[key], format)
+		}
+
+		// Some forks set the decoder explicitly, otherwise it's inferred from the supported attribute
+		if decoder := c.SelectAttrValue("decoder", ""); decoder != "" {
+			switch strings.ToLower(decoder) {
+			case "rawspeed":
+				camera.Decoder = "RawSpeed"
+			case "libraw":
+				camera.Decoder = "LibRaw"
+			case "partial":
+				camera.Decoder = "Partial"
+			default:
+				// Other values would add up as a decoder of their own in the statistics
+				camera.Decoder = "Unknown"
+				debug = append(debug, "cameras.xml: Unrecognised decoder attribute "+decoder)
+			}
+			debug = append(debug, "cameras.xml: Decoder from decoder attribute")
+		} else if camera.RSSupported == "" {
+			camera.Decoder = "RawSpeed"
+		} else if options.partial == true && camera.RSSupported != "no" {
+			camera.Decoder = "Partial"
+		}
+
+		camera.Aliases = dedupAliases(camera.Aliases)
+
+		camera.Sources = appendUnique(camera.Sources, "rawspeed")
+		camera.Debug = append(camera.Debug, debug...)
+		cameras[key] = camera
+	}
+	return supportedModes
+}
+
+func loadLibRaw(cameras map[string]camera, options options) {
+	inStruct := false
+	maker := ""
+	model := ""
+	alias := ""
+
+	librawData := string(getData(options.librawPath, options.minSize["libraw"]))
+	scanner := bufio.NewScanner(strings.NewReader(librawData))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.Contains(line, "const model_map_t modelMap[] = {") {
+			inStruct = true
+			continue
+		} else if inStruct == false {
+			continue
+		} else if strings.Contains(line, "};") && inStruct == true {
+			break
+		}
+
+		re := regexp.MustCompile(`".+"`)
+		foundStr := strings.Trim(re.FindString(line), "\"")
+		if strings.Contains(line, ".clean_make =") {
+			maker = foundStr
+		} else if strings.Contains(line, ".clean_model =") {
+			model = foundStr
+		} else if strings.Contains(line, ".clean_alias =") {
+			alias = foundStr
+		}
+
+		if strings.Contains(line, "},") {
+			key := cameraKey(maker, model)
+			camera := cameras[key]
+
+			if alias == "" || strings.EqualFold(model, alias) {
+				// Not an alias
+			} else if slices.ContainsFunc(camera.Aliases, func(a string) bool { return strings.EqualFold(a, alias) }) {
+				camera.Debug = append(camera.Debug, "imageio_libraw.c: Skipped duplicate alias "+alias)
+			} else {
+				camera.Aliases = dedupAliases(append(camera.Aliases, alias))
+			}
+
+			camera.Maker = maker
+			camera.Model = model
+			// LibRaw is loaded after cameras.xml, so it takes precedence. Flag it for review
+			if camera.Decoder == "RawSpeed" {
+				warnf("decoder-downgrade", maker, model, "imageio_libraw.c: %v %v is supported by RawSpeed, but labeled LibRaw", maker, model)
+			}
+			camera.Decoder = "LibRaw"
+			camera.Sources = appendUnique(camera.Sources, "libraw")
+			cameras[key] = camera
+
+			// Entries may omit fields, which mustn't carry over to the next entry
+			maker, model, alias = "", "", ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal("Error reading imageio_libraw.c: ", err)
+	}
+
+	if inStruct == false {
+		log.Fatal("No LibRaw cameras found in ", options.librawPath)
+	}
+}
+
+func loadWBPresets(cameras map[string]camera, options options) {
+	data := getData(options.wbpresetsPath, options.minSize["wbpresets"])
+	if options.strictSchema == true {
+		if err := checkCalibration(data, "wb_presets"); err != nil {
+			log.Fatal("wb_presets.json doesn't match the expected structure: ", err)
+		}
+	}
+	loadCalibration(cameras, data, "wb_presets.json", "wb_presets", "wbpresets", func(c *camera) { c.WBPresets = true })
+}
+
+func loadNoiseProfiles(cameras map[string]camera, options options) {
+	data := getData(options.noiseprofilesPath, options.minSize["noiseprofiles"])
+	if options.strictSchema == true {
+		if err := checkCalibration(data, "noiseprofiles"); err != nil {
+			log.Fatal("noiseprofiles.json doesn't match the expected structure: ", err)
+		}
+	}
+	loadCalibration(cameras, data, "noiseprofiles.json", "noiseprofiles", "noiseprofiles", func(c *camera) {
+		c.NoiseProfiles = true
+		c.NoiseProfileSource = appendUnique(c.NoiseProfileSource, "noiseprofiles")
+	})
+}
+
+// Second noise profile file, e.g. with auto-generated profiles. Merged like loadNoiseProfiles
+func loadNoiseProfilesExtra(cameras map[string]camera, options options) {
+	data := getData(options.noiseprofilesExtraPath, 0)
+	if options.strictSchema == true {
+		if err := checkCalibration(data, "noiseprofiles"); err != nil {
+			log.Fatal("-noiseprofiles-extra doesn't match the expected structure: ", err)
+		}
+	}
+	loadCalibration(cameras, data, path.Base(options.noiseprofilesExtraPath), "noiseprofiles", "noiseprofiles-extra", func(c *camera) {
+		c.NoiseProfiles = true
+		c.NoiseProfileSource = appendUnique(c.NoiseProfileSource, "noiseprofiles-extra")
+	})
+}
+
+// Structure the parser relies on, for -strict-schema. Unknown elements and attributes are allowed
+func checkCamerasXML(root *etree.Element) error {
+	if root == nil {
+		return errors.New("no <Cameras> root element")
+	}
+	cameraElements := root.SelectElements("Camera")
+	if len(cameraElements) == 0 {
+		return errors.New("no <Camera> elements")
+	}
+
+	for i, c := range cameraElements {
+		where := fmt.Sprintf("<Camera> %v (make=%q model=%q)", i+1, c.SelectAttrValue("make", ""), c.SelectAttrValue("model", ""))
+
+		if id := c.SelectElement("ID"); id != nil {
+			if id.SelectAttrValue("make", "") == "" || id.SelectAttrValue("model", "") == "" {
+				return fmt.Errorf("%v: <ID> needs make and model attributes", where)
+			}
+		} else if c.SelectAttrValue("make", "") == "" || c.SelectAttrValue("model", "") == "" {
+			return fmt.Errorf("%v: needs an <ID> element or make and model attributes", where)
+		}
+
+		if aliases := c.SelectElement("Aliases"); aliases != nil {
+			for _, a := range aliases.ChildElements() {
+				if a.Tag != "Alias" {
+					return fmt.Errorf("%v: unexpected <%v> in <Aliases>", where, a.Tag)
+				}
+				if a.SelectAttrValue("id", "") == "" && strings.TrimSpace(a.Text()) == "" {
+					return fmt.Errorf("%v: <Alias> without id or text", where)
+				}
+			}
+		}
+
+		if hints := c.SelectElement("Hints"); hints != nil {
+			for _, h := range hints.ChildElements() {
+				if h.Tag != "Hint" || h.SelectAttrValue("name", "") == "" {
+					return fmt.Errorf("%v: <Hints> may only hold <Hint> elements with a name attribute", where)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Structure of wb_presets.json and noiseprofiles.json the parser relies on, for -strict-schema.
+// Unknown keys are allowed
+func checkCalibration(data []byte, rootKey string) error {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("not a JSON object: %v", err)
+	}
+	raw, ok := root[rootKey]
+	if !ok {
+		return fmt.Errorf("no %q key", rootKey)
+	}
+
+	var makers []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &makers); err != nil {
+		return fmt.Errorf("%q is not a list of objects: %v", rootKey, err)
+	}
+	for i, m := range makers {
+		var maker string
+		if err := json.Unmarshal(m["maker"], &maker); err != nil || maker == "" {
+			return fmt.Errorf("%v[%v]: \"maker\" must be a non-empty string", rootKey, i)
+		}
+
+		var models []map[string]json.RawMessage
+		if err := json.Unmarshal(m["models"], &models); err != nil {
+			return fmt.Errorf("%v[%v] (%v): \"models\" must be a list of objects", rootKey, i, maker)
+		}
+		for j, mo := range models {
+			var model string
+			if err := json.Unmarshal(mo["model"], &model); err != nil || model == "" {
+				return fmt.Errorf("%v[%v].models[%v] (%v): \"model\" must be a non-empty string", rootKey, i, j, maker)
+			}
+		}
+	}
+	return nil
+}
+
+// wb_presets.json and noiseprofiles.json share a layout, with the cameras listed under rootKey
+type calibrationMakers []struct {
+	Maker  string `json:"maker"`
+	Models []struct {
+		Model    string            `json:"model"`
+		Presets  []json.RawMessage `json:"presets"`  // wb_presets.json
+		Profiles []json.RawMessage `json:"profiles"` // noiseprofiles.json
+	} `json:"models"`
+}
+
+func parseCalibration(data []byte, fileName string, rootKey string) calibrationMakers {
+	var root map[string]json.RawMessage
+	err := json.Unmarshal(data, &root)
+	if err != nil {
+		log.Fatalf("Unable to unmarshal %v: %v", fileName, err)
+	}
+
+	var makers calibrationMakers
+	if raw, ok := root[rootKey]; ok {
+		if err := json.Unmarshal(raw, &makers); err != nil {
+			log.Fatalf("Unable to unmarshal %v: %v", fileName, err)
+		}
+	}
+	return makers
+}
+
+// set marks the camera as having the calibration data
+func loadCalibration(cameras map[string]camera, data []byte, fileName string, rootKey string, source string, set func(c *camera)) {
+	makers := parseCalibration(data, fileName, rootKey)
+
+	for _, v := range makers {
+		for _, m := range v.Models {
+			key := cameraKey(v.Maker, m.Model)
+			camera := cameras[key]
+			if camera.Maker == "" { // Camera isn't present in cameras.xml or imageio_libraw.c
+				camera.Decoder = "Unknown"
+				camera.Debug = append(camera.Debug, "Source: "+fileName)
+			} else if camera.Decoder == "" {
+				camera.Debug = append(camera.Debug, fileName+": No decoder")
+			}
+			camera.Maker = v.Maker
+			camera.Model = m.Model
+			set(&camera)
+			camera.Sources = appendUnique(camera.Sources, source)
+			cameras[key] = camera
+		}
+	}
+}
+
+// Markdown changelog of the cameras added to, removed from or changed in the calibration files
+func generateCalibrationDiff(w io.Writer, options options) {
+	io.WriteString(w, "## Calibration changes\n")
+
+	diffs := []struct {
+		title, oldPath, newPath, fileName, rootKey, unit, source string
+	}{
+		{"White balance presets", options.oldWBPresetsPath, options.wbpresetsPath, "wb_presets.json", "wb_presets", "preset(s)", "wbpresets"},
+		{"Noise profiles", options.oldNoiseProfilesPath, options.noiseprofilesPath, "noiseprofiles.json", "noiseprofiles", "profile(s)", "noiseprofiles"},
+	}
+	for _, d := range diffs {
+		if d.oldPath == "" {
+			continue
+		}
+		oldCounts := calibrationCounts(parseCalibration(getData(d.oldPath, 0), d.fileName, d.rootKey))
+		newCounts := calibrationCounts(parseCalibration(getData(d.newPath, options.minSize[d.source]), d.fileName, d.rootKey))
+
+		added, removed, changed := []string{}, []string{}, []string{}
+		for _, k := range sortedKeys(newCounts) {
+			n := newCounts[k]
+			if o, ok := oldCounts[k]; !ok {
+				added = append(added, fmt.Sprintf("%v %v (%v %v)", n.maker, n.model, n.count, d.unit))
+			} else if o.count != n.count {
+				changed = append(changed, fmt.Sprintf("%v %v: %v → %v %v", n.maker, n.model, o.count, n.count, d.unit))
+			}
+		}
+		for _, k := range sortedKeys(oldCounts) {
+			if _, ok := newCounts[k]; !ok {
+				o := oldCounts[k]
+				removed = append(removed, fmt.Sprintf("%v %v (%v %v)", o.maker, o.model, o.count, d.unit))
+			}
+		}
+
+		fmt.Fprintf(w, "\n### %v\n", d.title)
+		if len(added)+len(removed)+len(changed) == 0 {
+			io.WriteString(w, "\nNo changes.\n")
+		}
+		for _, l := range []struct {
+			heading string
+			items   []string
+		}{{"Added", added}, {"Removed", removed}, {"Changed", changed}} {
+			if len(l.items) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\n%v:\n\n", l.heading)
+			for _, i := range l.items {
+				fmt.Fprintf(w, "- %v\n", i)
+			}
+		}
+	}
+}
+
+type calibrationCount struct {
+	maker, model string
+	count        int
+}
+
+// Number of presets or profiles per camera key
+func calibrationCounts(makers calibrationMakers) map[string]calibrationCount {
+	counts := map[string]calibrationCount{}
+	for _, v := range makers {
+		for _, m := range v.Models {
+			key := cameraKey(v.Maker, m.Model)
+			c := counts[key]
+			c.maker, c.model = v.Maker, m.Model
+			c.count += len(m.Presets) + len(m.Profiles)
+			counts[key] = c
+		}
+	}
+	return counts
+}
+
+// Returns DNG cameras that aren't in cameras
+func loadRawSpeedDNG(cameras map[string]camera, options options) []dngCamera {
+	orphans := []dngCamera{}
+
+	for _, c := range readRawSpeedDNG(options) {
+		key := cameraKey(c.Maker, c.Model)
+
+		camera, ok := cameras[key]
+		if ok {
+			camera.Decoder = "RawSpeed"
+			camera.Debug = append(camera.Debug, "rawspeed-dng: Decoder set")
+			camera.Sources = appendUnique(camera.Sources, "rawspeeddng")
+			cameras[key] = camera
+		} else {
+			if options.report != "dng-orphans" {
+				warnf("dng-orphan", c.Maker, c.Model, "rawspeed-dng: %v %v not found in cameras", c.Maker, c.Model)
+			}
+			orphans = append(orphans, c)
+		}
+	}
+
+	return orphans
+}
+
+// Reads the DNG list, which is either CSV or, if the path ends in .json, a JSON array
+func readRawSpeedDNG(options options) []dngCamera {
+	dngCameras := []dngCamera{}
+	data := getData(options.rawspeedDNGPath, options.minSize["rawspeeddng"])
+
+	if strings.HasSuffix(strings.ToLower(options.rawspeedDNGPath), ".json") {
+		if err := json.Unmarshal(data, &dngCameras); err != nil {
+			log.Fatal("Unable to unmarshal rawspeed-dng.json: ", err)
+		}
+		return dngCameras
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = options.dngDelimiter
+	reader.FieldsPerRecord = 0 // All rows must have as many fields as the first
+	for first := true; ; first = false {
+		c, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			log.Fatalf("Cannot read rawspeed-dng.csv: %v. Check the delimiter, see -dng-delimiter\n", err)
+		}
+		if err != nil {
+			log.Fatal("Cannot read rawspeed-dng.csv: ", err)
+		}
+		if len(c) < 2 {
+			line, _ := reader.FieldPos(0)
+			log.Fatalf("Cannot read rawspeed-dng.csv: line %v has %v field(s), expected Maker and Model. Check the delimiter, see -dng-delimiter\n", line, len(c))
+		}
+
+		if first && (options.dngHasHeader == "true" || options.dngHasHeader == "auto" && c[0] == "Maker" && c[1] == "Model") {
+			continue
+		}
+
+		dngCameras = append(dngCameras, dngCamera{Maker: c[0], Model: c[1]})
+	}
+
+	return dngCameras
+}
+
+// Whether a camera counts, given -unknown and -unsupported
+func isIncluded(c camera, options options) bool {
+	if c.Decoder == "" {
+		return options.unsupported
+	} else if c.Decoder == "Unknown" {
+		return options.unknown
+	}
+	return true
+}
+
+// Merges cameras listed under different names in different sources.
+// The CSV has Maker, Model and Equivalent columns, where Equivalent gets merged into Model
+func mergeEquivalents(cameras map[string]camera, options options) {
+	reader := csv.NewReader(bytes.NewReader(getData(options.equivalentsPath, 0)))
+	reader.FieldsPerRecord = 3
+
+	for {
+		e, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Cannot read equivalents file: ", err)
+		}
+
+		maker, model, equivalent := e[0], e[1], e[2]
+		if maker == "Maker" && model == "Model" && equivalent == "Equivalent" {
+			continue
+		}
+
+		key := cameraKey(maker, model)
+		equivalentKey := cameraKey(maker, equivalent)
+		primary, ok := cameras[key]
+		secondary, okEquivalent := cameras[equivalentKey]
+		if !ok || !okEquivalent {
+			warnf("equivalent-not-found", maker, model, "equivalents: %v %v or %v not found in cameras", maker, model, equivalent)
+			continue
+		}
+
+		primary = mergeCamera(primary, secondary)
+		primary.Aliases = dedupAliases(append(primary.Aliases, equivalent))
+		primary.Debug = append(primary.Debug, "equivalents: Merged "+equivalent)
+
+		cameras[key] = primary
+		delete(cameras, equivalentKey)
+	}
+}
+
+// Removes the cameras not listed in the -include CSV, which has Maker and Model columns
+func keepIncluded(cameras map[string]camera, options options) {
+	reader := csv.NewReader(bytes.NewReader(getData(options.includePath, 0)))
+	reader.FieldsPerRecord = 2
+
+	included := map[string]bool{}
+	for {
+		e, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Cannot read include file: ", err)
+		}
+
+		maker, model := e[0], e[1]
+		if maker == "Maker" && model == "Model" {
+			continue
+		}
+
+		key := cameraKey(maker, model)
+		if _, ok := cameras[key]; !ok {
+			warnf("include-not-found", maker, model, "include: %v %v not found in cameras", maker, model)
+			continue
+		}
+		included[key] = true
+	}
+
+	for k := range cameras {
+		if !included[k] {
+			delete(cameras, k)
+		}
+	}
+}
+
+// Removes the cameras without a mode matching -format-filter
+func keepFormats(cameras map[string]camera, options options) {
+	for k, c := range cameras {
+		if !slices.ContainsFunc(c.Formats, options.formatFilter.MatchString) {
+			delete(cameras, k)
+		}
+	}
+}
+
+// Adds the data of secondary to primary, keeping the Maker and Model of primary
+func mergeCamera(primary camera, secondary camera) camera {
+	primary.Aliases = dedupAliases(append(primary.Aliases, secondary.Aliases...))
+	primary.Formats = append(primary.Formats, secondary.Formats...)
+	slices.Sort(primary.Formats)
+	primary.Formats = slices.Compact(primary.Formats)
+	primary.Hints = append(primary.Hints, secondary.Hints...)
+	slices.Sort(primary.Hints)
+	primary.Hints = slices.Compact(primary.Hints)
+	for _, n := range secondary.Notes {
+		primary.Notes = appendUnique(primary.Notes, n)
+	}
+	for _, s := range secondary.Sources {
+		primary.Sources = appendUnique(primary.Sources, s)
+	}
+	for _, s := range secondary.NoiseProfileSource {
+		primary.NoiseProfileSource = appendUnique(primary.NoiseProfileSource, s)
+	}
+	primary.WBPresets = primary.WBPresets || secondary.WBPresets
+	primary.NoiseProfiles = primary.NoiseProfiles || secondary.NoiseProfiles
+	if (primary.Decoder == "" || primary.Decoder == "Unknown") && secondary.Decoder != "" {
+		primary.Decoder = secondary.Decoder
+	}
+	primary.Debug = append(primary.Debug, secondary.Debug...)
+	return primary
+}
+
+// Cameras keyed separately because their maker or model differ only in case or surrounding whitespace.
+// Warns about each, and with -merge-near-duplicates merges them into the first in key order
+func findNearDuplicates(cameras map[string]camera, options options) {
+	groups := map[string][]string{}
+	normalized := []string{}
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		n := cameraKey(strings.ToLower(strings.TrimSpace(c.Maker)), strings.ToLower(strings.TrimSpace(c.Model)))
+		if len(groups[n]) == 0 {
+			normalized = append(normalized, n)
+		}
+		groups[n] = append(groups[n], k)
+	}
+
+	for _, n := range normalized {
+		keys := groups[n]
+		if len(keys) < 2 {
+			continue
+		}
+		primary := cameras[keys[0]]
+		for _, k := range keys[1:] {
+			secondary := cameras[k]
+			warnf("near-duplicate", primary.Maker, primary.Model, "%q %q and %q %q differ only in case or whitespace", primary.Maker, primary.Model, secondary.Maker, secondary.Model)
+			if options.mergeNearDuplicates == true {
+				primary = mergeCamera(primary, secondary)
+				primary.Debug = append(primary.Debug, fmt.Sprintf("Merged near duplicate %q %q", secondary.Maker, secondary.Model))
+				delete(cameras, k)
+			}
+		}
+		cameras[keys[0]] = primary
+	}
+}
+
+// Aliases with WB presets or noise profiles of their own. Rows for aliases inherit the parent's flags,
+// so such an alias may rather need to be its own camera
+func checkAliasCalibration(cameras map[string]camera) {
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		for _, alias := range c.Aliases {
+			aliasCamera, ok := cameras[cameraKey(c.Maker, alias)]
+			if !ok {
+				continue
+			}
+			files := []string{}
+			if slices.Contains(aliasCamera.Sources, "wbpresets") {
+				files = append(files, "wb_presets.json")
+			}
+			if slices.Contains(aliasCamera.Sources, "noiseprofiles") || slices.Contains(aliasCamera.Sources, "noiseprofiles-extra") {
+				files = append(files, "noiseprofiles.json")
+			}
+			if len(files) > 0 {
+				warnf("alias-calibration", c.Maker, alias, "%v %v is an alias of %v, but has its own entry in %v", c.Maker, alias, c.Model, strings.Join(files, " and "))
+			}
+		}
+	}
+}
+
+// Cameras with RawSpeed modes and no decoder, although cameras.xml doesn't mark those modes unsupported,
+// e.g. supported="no-samples" without -partial. Likely a parsing bug or an inconsistent supported attribute
+func checkFormatsDecoder(cameras map[string]camera, supportedModes map[string][]string) {
+	for _, k := range sortedKeys(supportedModes) {
+		if c, ok := cameras[k]; ok && c.Decoder == "" {
+			warnf("formats-no-decoder", c.Maker, c.Model, "%v %v has modes %v not marked unsupported, but no decoder", c.Maker, c.Model, strings.Join(supportedModes[k], ", "))
+		}
+	}
+}
+
+// Cameras darktable has WB presets or noise profiles for but can't decode,
+// because of a naming mismatch between the sources or missing decoder support
+func checkCalibrationDecoder(cameras map[string]camera) {
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		if hasCalibrationWithoutDecoder(c) {
+			warnf("calibration-no-decoder", c.Maker, c.Model, "%v %v has WB presets or noise profiles but no decoder (%v)", c.Maker, c.Model, strings.Join(c.Sources, ", "))
+		}
+	}
+}
+
+func hasCalibrationWithoutDecoder(c camera) bool {
+	return (c.WBPresets == true || c.NoiseProfiles == true) && (c.Decoder == "" || c.Decoder == "Unknown")
+}
+
+// Aliases, compared case-insensitively, used by cameras of more than one maker.
+// Informational, they may need the maker prefix to tell them apart
+func checkSharedAliases(cameras map[string]camera) {
+	users := map[string][]camera{}
+	aliases := []string{}
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		for _, a := range c.Aliases {
+			alias := strings.ToLower(a)
+			if len(users[alias]) == 0 {
+				aliases = append(aliases, a) // Spelling of the first camera
+			}
+			users[alias] = append(users[alias], c)
+		}
+	}
+
+	for _, alias := range aliases {
+		makers, names := []string{}, []string{}
+		for _, c := range users[strings.ToLower(alias)] {
+			makers = appendUnique(makers, c.Maker)
+			names = append(names, c.Maker+" "+c.Model)
+		}
+		if len(makers) > 1 {
+			first := users[strings.ToLower(alias)][0]
+			warnf("shared-alias", first.Maker, first.Model, "Alias %q is shared by %v", alias, strings.Join(names, ", "))
+		}
+	}
+}
+
+// Models, compared case-insensitively, of cameras of more than one maker, e.g. cross-vendor OEM bodies.
+// They are kept apart by the maker, so this is only for checking it's intentional
+func checkSharedModels(cameras map[string]camera) {
+	makers := map[string][]string{}
+	models := []string{}
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		model := strings.ToLower(strings.TrimSpace(c.Model))
+		if model == "" {
+			continue
+		}
+		if len(makers[model]) == 0 {
+			models = append(models, c.Model)
+		}
+		makers[model] = appendUnique(makers[model], c.Maker)
+	}
+
+	for _, model := range models {
+		if m := makers[strings.ToLower(strings.TrimSpace(model))]; len(m) > 1 {
+			warnf("shared-model", m[0], model, "Model %q is used by the makers %v", model, strings.Join(m, ", "))
+		}
+	}
+}
+
+// Annotations file is a JSON array of {"maker", "model", "note"} objects. A camera can have several.
+// Objects with "mode" and "experimental": true instead mark one of the camera's modes as experimental
+func loadAnnotations(cameras map[string]camera, options options) {
+	type Annotation struct {
+		Maker        string `json:"maker"`
+		Model        string `json:"model"`
+		Note         string `json:"note"`
+		Mode         string `json:"mode"`
+		Experimental bool   `json:"experimental"`
+	}
+
+	var annotations []Annotation
+	if err := json.Unmarshal(getData(options.annotationsPath, 0), &annotations); err != nil {
+		log.Fatal("Unable to unmarshal annotations file: ", err)
+	}
+
+	for _, a := range annotations {
+		key := cameraKey(a.Maker, a.Model)
+		camera, ok := cameras[key]
+		if !ok {
+			warnf("annotation-not-found", a.Maker, a.Model, "annotations: %v %v not found in cameras", a.Maker, a.Model)
+			continue
+		}
+		if a.Experimental == true {
+			if !slices.Contains(camera.Formats, a.Mode) {
+				warnf("annotation-not-found", a.Maker, a.Model, "annotations: %v %v has no mode %q", a.Maker, a.Model, a.Mode)
+				continue
+			}
+			camera.ExperimentalFormats = appendUnique(camera.ExperimentalFormats, a.Mode)
+		}
+		if a.Note != "" {
+			camera.Notes = appendUnique(camera.Notes, a.Note)
+		}
+		cameras[key] = camera
+	}
+}
+
+// Aliases file is a JSON array of {"maker", "model", "aliases"} objects. Aliases a camera already has,
+// in any case, or matching its model are skipped
+func loadAliasesExtra(cameras map[string]camera, options options) {
+	type extraAliases struct {
+		Maker   string   `json:"maker"`
+		Model   string   `json:"model"`
+		Aliases []string `json:"aliases"`
+	}
+
+	var entries []extraAliases
+	if err := json.Unmarshal(getData(options.aliasesExtraPath, 0), &entries); err != nil {
+		log.Fatal("Unable to unmarshal aliases file: ", err)
+	}
+
+	for _, e := range entries {
+		key := cameraKey(e.Maker, e.Model)
+		camera, ok := cameras[key]
+		if !ok {
+			warnf("alias-not-found", e.Maker, e.Model, "aliases-extra: %v %v not found in cameras", e.Maker, e.Model)
+			continue
+		}
+		for _, a := range e.Aliases {
+			a = strings.TrimSpace(a)
+			if a == "" || strings.EqualFold(a, camera.Model) || slices.ContainsFunc(camera.Aliases, func(existing string) bool { return strings.EqualFold(existing, a) }) {
+				continue
+			}
+			camera.Aliases = append(camera.Aliases, a)
+			camera.Debug = append(camera.Debug, "aliases-extra: Added alias "+a)
+		}
+		camera.Aliases = dedupAliases(camera.Aliases)
+		camera.Sources = appendUnique(camera.Sources, "aliases-extra")
+		cameras[key] = camera
+	}
+}
+
+// Rewrites makers to their canonical name, merging cameras that then have the same maker and model.
+// Warns about unmapped makers that start with a mapped one, or the other way around
+func canonicalizeMakers(cameras map[string]camera, options options) {
+	var canonical map[string]string
+	if err := json.Unmarshal(getData(options.makerCanonicalPath, 0), &canonical); err != nil {
+		log.Fatal("Unable to unmarshal maker canonicalization file: ", err)
+	}
+
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		to, ok := canonical[c.Maker]
+		if !ok || to == c.Maker {
+			continue
+		}
+		delete(cameras, k)
+		c.Debug = append(c.Debug, fmt.Sprintf("Maker %v rewritten to %v", c.Maker, to))
+		c.Maker = to
+		key := cameraKey(c.Maker, c.Model)
+		if existing, ok := cameras[key]; ok {
+			c = mergeCamera(existing, c)
+		}
+		cameras[key] = c
+	}
+
+	known := map[string]bool{}
+	for from, to := range canonical {
+		known[from], known[to] = true, true
+	}
+	makers := []string{}
+	for _, c := range cameras {
+		makers = appendUnique(makers, c.Maker)
+	}
+	slices.Sort(makers)
+	for _, m := range makers {
+		if known[m] {
+			continue
+		}
+		for _, k := range sortedKeys(known) {
+			lm, lk := strings.ToLower(m), strings.ToLower(k)
+			if strings.HasPrefix(lm, lk+" ") || strings.HasPrefix(lk, lm+" ") {
+				warnf("maker-variant", m, "", "Maker %v looks like a variant of %v, but isn't in -maker-canonical", m, k)
+				break
+			}
+		}
+	}
+}
+
+// Malformed data can have cameras without a maker, which would otherwise get an empty segment header
+func bucketEmptyMakers(cameras map[string]camera) {
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		if strings.TrimSpace(c.Maker) != "" {
+			continue
+		}
+
+		warnf("empty-maker", c.Maker, c.Model, "Camera without maker: %v (%v)", c.Model, strings.Join(c.Sources, ", "))
+		delete(cameras, k)
+		c.Maker = unknownMaker
+		key := cameraKey(c.Maker, c.Model)
+		// The same model may come without a maker from several sources, e.g. "" and " "
+		if existing, ok := cameras[key]; ok {
+			c = mergeCamera(existing, c)
+		} else {
+			c.Debug = append(c.Debug, "No maker")
+		}
+		cameras[key] = c
+	}
+}
+
+func saveMerged(cameras map[string]camera, options options) {
+	if err := os.WriteFile(options.saveMerged, marshalMerged(cameras), options.outputMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// All cameras with every field, keyed by camera key
+func marshalMerged(cameras map[string]camera) []byte {
+	data, err := json.MarshalIndent(cameras, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return append(data, '\n')
+}
+
+func loadMerged(path string) map[string]camera {
+	cameras := map[string]camera{}
+	if err := json.Unmarshal(getData(path, 0), &cameras); err != nil {
+		log.Fatal("Unable to unmarshal merged camera data: ", err)
+	}
+	return cameras
+}
+
+func generateStats(cameras map[string]camera, options options) stats {
+
+	s := stats{}
+	formatCameras, formats := 0, 0
+
+	for _, c := range cameras {
+		if hasCalibrationWithoutDecoder(c) {
+			s.calibrationWithoutDecoder += 1
+		}
+
+		if !isIncluded(c, options) {
+			continue
+		}
+
+		if (c.Decoder == "RawSpeed" || c.Decoder == "LibRaw") && len(c.Formats) > 0 {
+			distinct := slices.Clone(c.Formats)
+			slices.Sort(distinct)
+			distinct = slices.Compact(distinct)
+			formatCameras += 1
+			formats += len(distinct)
+			if len(distinct) > 1 {
+				s.multiFormat += 1
+			}
+		}
+
+		switch c.Decoder {
+		case "":
+			s.unsupported += 1
+		case "Unknown":
+			s.unknown += 1
+		case "RawSpeed":
+			s.rawspeed += 1
+			s.supported += 1
+			s.calibration[boolIndex(c.WBPresets)][boolIndex(c.NoiseProfiles)] += 1
+		case "LibRaw":
+			s.libraw += 1
+			s.supported += 1
+			s.calibration[boolIndex(c.WBPresets)][boolIndex(c.NoiseProfiles)] += 1
+		case "Partial":
+			s.partial += 1
+		}
+
+		if c.Maker == unknownMaker {
+			s.noMaker += 1
+		}
+
+		s.aliases += len(c.Aliases)
+		s.experimentalFormats += len(c.ExperimentalFormats)
+
+		if c.NoiseProfiles == true {
+			s.noiseProfiles += 1
+		}
+
+		if c.WBPresets == true {
+			s.wbPresets += 1
+		}
+
+		s.cameras += 1
+	}
+
+	s.marketedTotal = s.cameras + s.aliases
+	s.rawspeedPercent = percentage(s.rawspeed, s.cameras, options)
+	s.librawPercent = percentage(s.libraw, s.cameras, options)
+	s.partialPercent = percentage(s.partial, s.cameras, options)
+	s.supportedPercent = percentage(s.supported, s.cameras, options)
+	s.unknownPercent = percentage(s.unknown, s.cameras, options)
+	s.unsupportedPercent = percentage(s.unsupported, s.cameras, options)
+	s.wbPresetsPercent = percentage(s.wbPresets, s.cameras, options)
+	s.noiseProfilePercent = percentage(s.noiseProfiles, s.cameras, options)
+	if formatCameras > 0 {
+		s.formatsAverage = math.Round(float64(formats)/float64(formatCameras)*100) / 100
+	}
+
+	return s
+}
+
+// Reports every maker below its -min-per-maker threshold before failing
+func checkMinPerMaker(cameras map[string]camera, options options) {
+	counts := map[string]int{}
+	for _, c := range cameras {
+		if isIncluded(c, options) {
+			counts[strings.ToLower(c.Maker)] += 1
+		}
+	}
+
+	makers := sortedKeys(options.minPerMaker)
+	failed := 0
+	for _, maker := range makers {
+		count, min := counts[strings.ToLower(maker)], options.minPerMaker[maker]
+		if count < min {
+			fmt.Fprintf(os.Stderr, "%v has %v model(s), expected at least %v (%v short)\n", maker, count, min, min-count)
+			failed += 1
+		}
+	}
+
+	if failed > 0 {
+		log.Fatalf("Failed due to %v maker(s) below -min-per-maker\n", failed)
+	}
+}
+
+// Models and aliases of all included cameras, sorted and without duplicates
+func listModels(w io.Writer, cameras map[string]camera, options options) {
+	names := []string{}
+	for _, c := range cameras {
+		if isIncluded(c, options) && c.Model != "" {
+			names = append(append(names, c.Model), c.Aliases...)
+		}
+	}
+	slices.Sort(names)
+
+	for _, n := range slices.Compact(names) {
+		fmt.Fprintln(w, n)
+	}
+}
+
+// Cameras without a maker or model are left out
+func listAbout(w io.Writer, cameras map[string]camera, options options) {
+	lines := []string{}
+	for _, c := range cameras {
+		if !isIncluded(c, options) || c.Maker == unknownMaker || c.Model == "" {
+			continue
+		}
+		line := c.Maker + " " + c.Model
+		if options.aboutAliases == true && len(c.Aliases) > 0 {
+			line += " (" + strings.Join(c.Aliases, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	slices.Sort(lines)
+
+	for _, l := range slices.Compact(lines) {
+		fmt.Fprintln(w, l)
+	}
+}
+
+// 0 for true, 1 for false, like the order of -bools
+func boolIndex(b bool) int {
+	if b == true {
+		return 0
+	}
+	return 1
+}
+
+func countMakers(cameras map[string]camera, options options) map[string]int {
+	makerCounts := map[string]int{}
+	for _, c := range cameras {
+		if isIncluded(c, options) {
+			makerCounts[c.Maker] += 1
+		}
+	}
+	return makerCounts
+}
+
+func listMakers(cameras map[string]camera, options options) {
+	makerCounts := countMakers(cameras, options)
+
+	makers := make([]string, 0, len(makerCounts))
+	for m := range makerCounts {
+		makers = append(makers, m)
+	}
+	sort.Strings(makers)
+
+	for _, m := range makers {
+		fmt.Printf("%v\t%v\n", m, makerCounts[m])
+	}
+}
+
+func printStats(stats stats, options options) {
+	// Keep the columns aligned when percentages have decimals
+	pw := 3
+	if options.statsPrecision > 0 {
+		pw += options.statsPrecision + 1
+	}
+	pc := func(p float64) string { return fmt.Sprintf("%*v%%", pw, formatPercent(p, options)) }
+
+	useColor := options.noColor == false && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	green := func(s string) string { return colorize(s, "32", useColor) }
+	red := func(s string) string { return colorize(s, "31", useColor) }
+
+	fmt.Printf("Cameras:\t %4v\n", stats.cameras)
+	fmt.Printf("  RawSpeed:\t %4v  %v\n", stats.rawspeed, green(pc(stats.rawspeedPercent)))
+	fmt.Printf("  LibRaw:\t %4v  %v\n", stats.libraw, green(pc(stats.librawPercent)))
+	if options.partial == true {
+		fmt.Printf("  Partial:\t %4v  %v\n", stats.partial, pc(stats.partialPercent))
+	}
+	if options.unknown == true || options.unsupported == true {
+		fmt.Printf("  Supported:\t %4v  %v\n", stats.supported, green(pc(stats.supportedPercent)))
+	}
+	if options.unknown == true {
+		fmt.Printf("  Unknown:\t %4v  %v\n", stats.unknown, red(pc(stats.unknownPercent)))
+	}
+	if options.unsupported == true {
+		fmt.Printf("  Unsupported:\t %4v  %v\n", stats.unsupported, red(pc(stats.unsupportedPercent)))
+	}
+	if stats.noMaker > 0 {
+		fmt.Printf("  No maker:\t %4v\n", stats.noMaker)
+	}
+	fmt.Printf("Aliases:\t %4v\n", stats.aliases)
+	fmt.Printf("With aliases:\t %4v\n", stats.marketedTotal)
+	fmt.Printf("WB Presets:\t %4v  %v\n", stats.wbPresets, pc(stats.wbPresetsPercent))
+	fmt.Printf("Noise Profiles:\t %4v  %v\n", stats.noiseProfiles, pc(stats.noiseProfilePercent))
+	if options.stats.formats == true {
+		fmt.Printf("Formats:\t %4.2f  per camera\n", stats.formatsAverage)
+		fmt.Printf("  Multiple:\t %4v\n", stats.multiFormat)
+	}
+	if stats.experimentalFormats > 0 {
+		fmt.Printf("Experimental:\t %4v  formats\n", stats.experimentalFormats)
+	}
+	if stats.calibrationWithoutDecoder > 0 {
+		fmt.Printf("No decoder:\t %v  with WB presets or noise profiles\n", red(fmt.Sprintf("%4v", stats.calibrationWithoutDecoder)))
+	}
+	if options.stats.matrix == true {
+		fmt.Printf("\nSupported\t    NP   No NP\n")
+		fmt.Printf("  WB:\t\t %5v  %5v\n", stats.calibration[0][0], stats.calibration[0][1])
+		fmt.Printf("  No WB:\t %5v  %5v\n", stats.calibration[1][0], stats.calibration[1][1])
+	}
+}
+
+// One line of key=value pairs, e.g. for CI logs or commit messages
+func printCompactStats(stats stats, options options) {
+	pc := func(n int, p float64) string { return fmt.Sprintf("%v(%v%%)", n, formatPercent(p, options)) }
+	fmt.Printf("cameras=%v rawspeed=%v libraw=%v wb=%v np=%v\n", stats.cameras,
+		pc(stats.rawspeed, stats.rawspeedPercent), pc(stats.libraw, stats.librawPercent),
+		pc(stats.wbPresets, stats.wbPresetsPercent), pc(stats.noiseProfiles, stats.noiseProfilePercent))
+}
+
+func generateStatsJSON(w io.Writer, stats stats, options options) {
+	data, err := json.MarshalIndent(statsFields(stats, options), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Write(append(data, '\n'))
+}
+
+// Stats in -stats json order, also exposed to -template
+func statsFields(stats stats, options options) jsonObject {
+	obj := jsonObject{
+		{"cameras", stats.cameras},
+		{"rawspeed", stats.rawspeed},
+		{"rawspeedPercent", stats.rawspeedPercent},
+		{"libraw", stats.libraw},
+		{"librawPercent", stats.librawPercent},
+		{"partial", stats.partial},
+		{"partialPercent", stats.partialPercent},
+		{"supported", stats.supported},
+		{"supportedPercent", stats.supportedPercent},
+		{"unknown", stats.unknown},
+		{"unknownPercent", stats.unknownPercent},
+		{"unsupported", stats.unsupported},
+		{"unsupportedPercent", stats.unsupportedPercent},
+		{"noMaker", stats.noMaker},
+		{"aliases", stats.aliases},
+		{"marketedTotal", stats.marketedTotal},
+		{"wbPresets", stats.wbPresets},
+		{"wbPresetsPercent", stats.wbPresetsPercent},
+		{"noiseProfiles", stats.noiseProfiles},
+		{"noiseProfilesPercent", stats.noiseProfilePercent},
+		{"calibrationWithoutDecoder", stats.calibrationWithoutDecoder},
+	}
+	if options.stats.formats == true {
+		obj = append(obj, jsonField{"formatsAverage", stats.formatsAverage}, jsonField{"multiFormat", stats.multiFormat})
+	}
+	if stats.experimentalFormats > 0 {
+		obj = append(obj, jsonField{"experimentalFormats", stats.experimentalFormats})
+	}
+	if options.stats.matrix == true {
+		obj = append(obj,
+			jsonField{"wbAndNoiseProfiles", stats.calibration[0][0]},
+			jsonField{"wbOnly", stats.calibration[0][1]},
+			jsonField{"noiseProfilesOnly", stats.calibration[1][0]},
+			jsonField{"neither", stats.calibration[1][1]},
+		)
+	}
+	return obj
+}
+
+// Data passed to a -template
+type templateData struct {
+	Cameras []camera       // Output cameras in output order, respecting -unknown, -unsupported, -explode-aliases and -maker-order
+	Stats   map[string]any // Keys as in -stats json
+	Fields  []string       // -fields
+	Bools   []string       // -bools
+	Version string
+}
+
+func generateTemplate(w io.Writer, cameras map[string]camera, stats stats, options options) {
+	tmpl, err := template.New(path.Base(options.template)).Funcs(template.FuncMap{"join": strings.Join}).Parse(string(getData(options.template, 0)))
+	if err != nil {
+		log.Fatal("Cannot parse template: ", err)
+	}
+
+	_, outCameras := selectOutputCameras(cameras, options)
+	data := templateData{
+		Cameras: outCameras,
+		Stats:   map[string]any{},
+		Fields:  options.fields,
+		Bools:   options.bools,
+		Version: version,
+	}
+	for _, f := range statsFields(stats, options) {
+		data.Stats[f.key] = f.value
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Fatal("Cannot execute template: ", err)
+	}
+}
+
+func colorize(s string, ansiCode string, useColor bool) string {
+	if !useColor {
+		return s
+	}
+	return "\033[" + ansiCode + "m" + s + "\033[0m"
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Rounded to -stats-precision decimal places, as set by -rounding. Used for every percentage shown,
+// in the stats and the table headers alike. 0 if there's nothing to divide by
+func percentage(part int, total int, options options) float64 {
+	if total == 0 {
+		return 0
+	}
+	scale := math.Pow(10, float64(options.statsPrecision))
+	p := float64(part) / float64(total) * 100 * scale
+	switch options.rounding {
+	case "half-even":
+		p = math.RoundToEven(p)
+	case "floor":
+		p = math.Floor(p)
+	case "ceil":
+		p = math.Ceil(p)
+	default: // Percentages aren't negative, so away from zero is up
+		p = math.Round(p)
+	}
+	return p / scale
+}
+
+func formatPercent(p float64, options options) string {
+	return strconv.FormatFloat(p, 'f', options.statsPrecision, 64)
+}
+
+// Also returns the distinct notes in order of first use, which are footnotes in Markdown
+func prepareOutputData(cameras map[string]camera, options options) ([]tableRow, tableTotals, []string) {
+	data := make([]tableRow, 0, len(cameras))
+	totals := tableTotals{makers: map[string]columnTotals{}}
+	footnotes := []string{}
+
+	mdEscapes := strings.NewReplacer(
+		"\\", "\\\\",
+		"*", "\\*",
+		"_", "\\_",
+		"{", "\\{",
+		"}", "\\}",
+		"[", "\\[",
+		"]", "\\]",
+		"<", "\\<",
+		">", "\\>",
+		"(", "\\(",
+		")", "\\)",
+		"#", "\\#",
+	)
+	if options.escapeMode == "github" {
+		// Only characters that break a GitHub Flavored Markdown table cell
+		mdEscapes = strings.NewReplacer(
+			"\\", "\\\\",
+			"|", "\\|",
+		)
+	}
+
+	keys, outCameras := selectOutputCameras(cameras, options)
+	if options.segments != 0 && options.makerLimit != 0 {
+		keys, outCameras = limitMakers(keys, outCameras, options)
+	}
+
+	for _, c := range outCameras {
+		for _, n := range c.Notes {
+			footnotes = appendUnique(footnotes, n)
+		}
+	}
+
+	for i, c := range outCameras {
+		data = append(data, prepareRow(keys[i], c, mdEscapes, footnotes, options))
+
+		t := totals.makers[c.Maker]
+		t.models += 1
+		if c.WBPresets == true {
+			t.wbPresets += 1
+		}
+		if c.NoiseProfiles == true {
+			t.noiseProfiles += 1
+		}
+		totals.makers[c.Maker] = t
+	}
+
+	// Sum of the segments, so the full table and segment headers can't disagree
+	for _, maker := range sortedKeys(totals.makers) {
+		totals.all = totals.all.add(totals.makers[maker])
+	}
+
+	return data, totals, footnotes
+}
+
+// Cameras to output in order, with their keys. Aliases get their own camera with -explode-aliases
+func selectOutputCameras(cameras map[string]camera, options options) ([]string, []camera) {
+	keys := make([]string, 0, len(cameras))
+	outCameras := make([]camera, 0, len(cameras))
+
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+
+		if options.unsupported == false && c.Decoder == "" {
+			continue
+		}
+		if options.dropEmptyModel == true && strings.TrimSpace(c.Model) == "" {
+			continue
+		}
+
+		keys = append(keys, k)
+		outCameras = append(outCameras, c)
+
+		if options.explodeAliases == true {
+			for _, a := range c.Aliases {
+				aliasCamera := c
+				aliasCamera.Model = a
+				aliasCamera.Aliases = nil
+				aliasCamera.IsAlias = true
+				keys = append(keys, k)
+				outCameras = append(outCameras, aliasCamera)
+			}
+		}
+	}
+
+	if len(options.makerOrder) > 0 {
+		keys, outCameras = orderMakers(keys, outCameras, options)
+	}
+	if options.sort != "" {
+		keys, outCameras = sortRows(keys, outCameras, options)
+	}
+
+	return keys, outCameras
+}
+
+// Sorts by the -sort field, case-insensitively. With -segments rows stay in their maker's segment
+func sortRows(keys []string, outCameras []camera, options options) ([]string, []camera) {
+	fieldOptions := options
+	fieldOptions.fields = []string{options.sort}
+	fieldOptions.nullEmpty = false
+
+	segments := []string{}
+	groups := make([]int, len(outCameras))
+	values := make([]string, len(outCameras))
+	for i, c := range outCameras {
+		if options.segments != 0 {
+			segments = appendUnique(segments, c.Maker)
+			groups[i] = slices.Index(segments, c.Maker)
+		}
+		if options.sort == "calibration" {
+			// Kinds of calibration data present, so neither WB presets nor noise profiles comes first
+			values[i] = strconv.Itoa(2 - boolIndex(c.WBPresets) - boolIndex(c.NoiseProfiles))
+			continue
+		}
+		switch v := jsonCamera(c, fieldOptions)[0].value.(type) {
+		case []string:
+			values[i] = strings.ToLower(strings.Join(v, ", "))
+		default:
+			values[i] = strings.ToLower(fmt.Sprint(v))
+		}
+	}
+
+	order := make([]int, len(outCameras))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if groups[a] != groups[b] {
+			return groups[a] - groups[b]
+		}
+		return strings.Compare(values[a], values[b])
+	})
+
+	orderedKeys := make([]string, 0, len(keys))
+	orderedCameras := make([]camera, 0, len(outCameras))
+	for _, i := range order {
+		orderedKeys = append(orderedKeys, keys[i])
+		orderedCameras = append(orderedCameras, outCameras[i])
+	}
+	return orderedKeys, orderedCameras
+}
+
+// With -include-aliases-in-search, an alias starting with the maker also matches,
+// e.g. "Hasselblad" matches the Sony ILCE-7RM2 via its alias "Hasselblad Lunar"
+func matchesMaker(c camera, options options) bool {
+	for _, m := range options.makers {
+		if strings.EqualFold(c.Maker, m) {
+			return true
+		}
+		if options.includeAliasesInSearch == true {
+			for _, a := range c.Aliases {
+				if strings.EqualFold(a, m) || (len(a) > len(m) && strings.EqualFold(a[:len(m)+1], m+" ")) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Moves the -maker-order makers first. The sort is stable, so other makers stay alphabetical
+func orderMakers(keys []string, outCameras []camera, options options) ([]string, []camera) {
+	rank := func(maker string) int {
+		if i := slices.IndexFunc(options.makerOrder, func(m string) bool { return strings.EqualFold(m, maker) }); i != -1 {
+			return i
+		}
+		return len(options.makerOrder)
+	}
+
+	order := make([]int, len(outCameras))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return rank(outCameras[a].Maker) - rank(outCameras[b].Maker) })
+
+	orderedKeys := make([]string, 0, len(keys))
+	orderedCameras := make([]camera, 0, len(outCameras))
+	for _, i := range order {
+		orderedKeys = append(orderedKeys, keys[i])
+		orderedCameras = append(orderedCameras, outCameras[i])
+	}
+	return orderedKeys, orderedCameras
+}
+
+// Keeps the first -maker-limit makers, or the last if negative
+func limitMakers(keys []string, outCameras []camera, options options) ([]string, []camera) {
+	makers := []string{}
+	for _, c := range outCameras {
+		makers = appendUnique(makers, c.Maker)
+	}
+
+	n := min(abs(options.makerLimit), len(makers))
+	if options.makerLimit > 0 {
+		makers = makers[:n]
+	} else {
+		makers = makers[len(makers)-n:]
+	}
+
+	limitedKeys := []string{}
+	limitedCameras := []camera{}
+	for i, c := range outCameras {
+		if slices.Contains(makers, c.Maker) {
+			limitedKeys = append(limitedKeys, keys[i])
+			limitedCameras = append(limitedCameras, c)
+		}
+	}
+	return limitedKeys, limitedCameras
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func prepareRow(k string, c camera, mdEscapes *strings.Replacer, footnotes []string, options options) tableRow {
+	row := make([]string, 0, len(options.fields))
+
+	for _, f := range options.fields {
+		switch f {
+		case "maker":
+			row = append(row, c.Maker)
+		case "model":
+			if options.escape == true {
+				row = append(row, mdEscapes.Replace(c.Model))
+			} else {
+				row = append(row, c.Model)
+			}
+		case "aliases":
+			aliases := slices.Clone(c.Aliases)
+			if options.escape == true {
+				for i, a := range aliases {
+					aliases[i] = mdEscapes.Replace(a)
+				}
+			}
+			if options.format == "md" && options.wrapAliases > 0 {
+				// Line breaks within the cell, after every -wrap-aliases aliases
+				lines := []string{}
+				for i := 0; i < len(aliases); i += options.wrapAliases {
+					lines = append(lines, strings.Join(aliases[i:min(i+options.wrapAliases, len(aliases))], ", "))
+				}
+				row = append(row, strings.Join(lines, ",<br>"))
+			} else {
+				row = append(row, strings.Join(aliases, ", "))
+			}
+		case "formats":
+			if options.hideDefaultFormats == true && slices.Equal(c.Formats, []string{"default"}) {
+				row = append(row, "")
+			} else {
+				formats := slices.Clone(c.Formats)
+				for i, f := range formats {
+					if slices.Contains(c.ExperimentalFormats, f) {
+						formats[i] = f + "*"
+					}
+				}
+				row = append(row, strings.Join(formats, ", "))
+			}
+		case "wbpresets":
+			if c.WBPresets == true {
+				row = append(row, options.bools[0])
+			} else {
+				row = append(row, options.bools[1])
+			}
+		case "noiseprofiles":
+			if c.NoiseProfiles == true {
+				row = append(row, options.bools[0])
+			} else {
+				row = append(row, options.bools[1])
+			}
+		case "rssupported":
+			row = append(row, c.RSSupported)
+		case "supportstatus":
+			row = append(row, c.SupportStatus)
+		case "hints":
+			row = append(row, strings.Join(c.Hints, ", "))
+		case "notes":
+			if options.format == "md" {
+				refs := make([]string, 0, len(c.Notes))
+				for _, n := range c.Notes {
+					refs = append(refs, fmt.Sprintf("[^%v]", slices.Index(footnotes, n)+1))
+				}
+				row = append(row, strings.Join(refs, " "))
+			} else {
+				row = append(row, strings.Join(c.Notes, "; "))
+			}
+		case "decoder":
+			row = append(row, decoderLabel(c.Decoder, options))
+		case "status":
+			row = append(row, statusLabel(c, options))
+		case "isalias":
+			if c.IsAlias == true {
+				row = append(row, options.bools[0])
+			} else {
+				row = append(row, options.bools[1])
+			}
+		case "noiseprofilesource":
+			row = append(row, strings.Join(c.NoiseProfileSource, ", "))
+		case "isnew":
+			if c.IsNew == true {
+				row = append(row, options.bools[0])
+			} else {
+				row = append(row, options.bools[1])
+			}
+		case "sources":
+			row = append(row, strings.Join(c.Sources, ", "))
+		case "debug":
+			slices.Sort(c.Debug)
+			c.Debug = slices.Compact(c.Debug)
+			row = append(row, strings.Join(c.Debug, ", "))
+		}
+	}
+
+	if options.emptyPlaceholder != "" {
+		for i := range row {
+			if row[i] == "" {
+				row[i] = options.emptyPlaceholder
+			}
+		}
+	}
+
+	return tableRow{key: k, maker: c.Maker, fields: row}
+}
+
+// -decoder-labels apply to Markdown, and to other formats with -decoder-labels-all
+func decoderLabel(decoder string, options options) string {
+	if options.format != "md" && options.decoderLabelsAll == false {
+		return decoder
+	}
+	if label, ok := options.decoderLabels[strings.ToLower(decoder)]; ok {
+		return label
+	}
+	return decoder
+}
+
+// Decoder, or why there is none
+func statusLabel(c camera, options options) string {
+	switch c.Decoder {
+	case "":
+		return "Unsupported"
+	case "Unknown":
+		return "Unknown"
+	}
+	return decoderLabel(c.Decoder, options)
+}
+
+// Camera table in the selected format
+func writeTable(cameras map[string]camera, colHeaders map[string]string, stats stats, options options) {
+	if options.format == "json" || options.format == "ndjson" {
+		writeOutput(options, func(w io.Writer) {
+			generateJSON(w, cameras, options)
+		})
+	} else if options.format != "none" {
+		data, totals, footnotes := prepareOutputData(cameras, options)
+
+		writeOutput(options, func(w io.Writer) {
+			switch options.format {
+			case "md":
+				generateMD(w, data, totals, footnotes, colHeaders, stats, options)
+			case "tsv":
+				generateTSV(w, data, totals, cameras, colHeaders, options)
+			}
+		})
+	}
+}
+
+// One file per decoder or maker in the output directory, each with its own stats, and an index linking them.
+// Makers mapped to the same file name by -maker-filenames share the file
+func writeSplit(cameras map[string]camera, colHeaders map[string]string, options options) {
+	dir := options.output
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Fatal(err)
+	}
+
+	makerFilenames := map[string]string{}
+	if options.splitBy == "maker" && options.makerFilenamesPath != "" {
+		if err := json.Unmarshal(getData(options.makerFilenamesPath, 0), &makerFilenames); err != nil {
+			log.Fatal("Unable to unmarshal maker file names: ", err)
+		}
+	}
+
+	parts := map[string]map[string]camera{}
+	labels := map[string][]string{}
+	for _, k := range sortedKeys(cameras) {
+		c := cameras[k]
+		name, label := slugify(c.Decoder), c.Decoder
+		if options.splitBy == "maker" {
+			name, label = slugify(c.Maker), c.Maker
+			if mapped, ok := makerFilenames[c.Maker]; ok {
+				name = mapped
+			}
+		} else if name == "" {
+			name, label = "unsupported", "Unsupported"
+		}
+		labels[name] = appendUnique(labels[name], label)
+		if parts[name] == nil {
+			parts[name] = map[string]camera{}
+		}
+		parts[name][k] = c
+	}
+
+	// Known decoders first, then any others a merged or custom file brought in
+	names := sortedKeys(parts)
+	if options.splitBy == "decoder" {
+		known := []string{"rawspeed", "partial", "libraw", "unknown", "unsupported"}
+		names = append(known, slices.DeleteFunc(names, func(n string) bool { return slices.Contains(known, n) })...)
+	}
 
-		if c.NoiseProfiles == true {
-			s.noiseProfiles += 1
+	index := []string{}
+	for _, name := range names {
+		part := parts[name]
+		if len(part) == 0 {
+			continue
 		}
-
-		if c.WBPresets == true {
-			s.wbPresets += 1
+		stats := generateStats(part, options)
+		if stats.cameras == 0 {
+			continue
 		}
 
-		s.cameras += 1
-	}
+		fileName := name + "." + options.format
+		partOptions := options
+		partOptions.output = filepath.Join(dir, fileName)
+		writeTable(part, colHeaders, stats, partOptions)
 
-	s.rawspeedPercent = int(math.Round(float64(s.rawspeed) / float64(s.cameras) * 100))
-	s.librawPercent = int(math.Round(float64(s.libraw) / float64(s.cameras) * 100))
-	s.supportedPercent = int(math.Round(float64(s.supported) / float64(s.cameras) * 100))
-	s.unknownPercent = int(math.Round(float64(s.unknown) / float64(s.cameras) * 100))
-	s.unsupportedPercent = int(math.Round(float64(s.unsupported) / float64(s.cameras) * 100))
-	s.wbPresetsPercent = int(math.Round(float64(s.wbPresets) / float64(s.cameras) * 100))
-	s.noiseProfilePercent = int(math.Round(float64(s.noiseProfiles) / float64(s.cameras) * 100))
+		index = append(index, fmt.Sprintf("- [%v](%v) (%v cameras)\n", strings.Join(labels[name], ", "), fileName, stats.cameras))
+	}
 
-	return s
+	options.output = filepath.Join(dir, "index.md")
+	writeOutput(options, func(w io.Writer) {
+		for _, l := range index {
+			io.WriteString(w, l)
+		}
+	})
 }
 
-func prepareOutputData(cameras map[string]camera, options options) [][]string {
-	data := make([][]string, 0, len(cameras))
-
-	mdEscapes := strings.NewReplacer(
-		"\\", "\\\\",
-		"*", "\\*",
-		"_", "\\_",
-		"{", "\\{",
-		"}", "\\}",
-		"[", "\\[",
-		"]", "\\]",
-		"<", "\\<",
-		">", "\\>",
-		"(", "\\(",
-		")", "\\)",
-		"#", "\\#",
-	)
+// Opens the output file or stdout, and passes a buffered writer to generate
+func writeOutput(options options, generate func(w io.Writer)) {
+	out := os.Stdout
+	if options.output != "stdout" {
+		f, err := os.OpenFile(options.output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, options.outputMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out = f
+	}
 
-	// Maps can't be sorted, so use a separate sorted slice for the output order
-	camerasOrder := make([]string, 0, len(cameras))
-	for k := range cameras {
-		camerasOrder = append(camerasOrder, k)
+	var dst io.Writer = out
+	var gz *gzip.Writer
+	if out != os.Stdout && strings.HasSuffix(strings.ToLower(options.output), ".gz") {
+		gz = gzip.NewWriter(out)
+		dst = gz
 	}
-	sort.Strings(camerasOrder)
 
-	for _, k := range camerasOrder {
-		c := cameras[k]
+	// Write errors are sticky in bufio.Writer, so they are checked once on Flush
+	w := bufio.NewWriter(dst)
+	generate(w)
 
-		if options.unsupported == false && c.Decoder == "" {
-			continue
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			log.Fatal(err)
 		}
-
-		// First two fields in row are always cameras key and Maker, even if not requested
-		// They may be needed when generating the output
-		row := make([]string, 0, len(options.fields)+2)
-		row = append(row, k)
-		row = append(row, c.Maker)
-
-		for _, f := range options.fields {
-			switch f {
-			case "maker":
-				row = append(row, c.Maker)
-			case "model":
-				if options.escape == true {
-					row = append(row, mdEscapes.Replace(c.Model))
-				} else {
-					row = append(row, c.Model)
-				}
-			case "aliases":
-				if options.escape == true {
-					row = append(row, mdEscapes.Replace(strings.Join(c.Aliases, ", ")))
-				} else {
-					row = append(row, strings.Join(c.Aliases, ", "))
-				}
-			case "formats":
-				row = append(row, strings.Join(c.Formats, ", "))
-			case "wbpresets":
-				if c.WBPresets == true {
-					row = append(row, options.bools[0])
-				} else {
-					row = append(row, options.bools[1])
-				}
-			case "noiseprofiles":
-				if c.NoiseProfiles == true {
-					row = append(row, options.bools[0])
-				} else {
-					row = append(row, options.bools[1])
-				}
-			case "rssupported":
-				row = append(row, c.RSSupported)
-			case "decoder":
-				row = append(row, c.Decoder)
-			case "debug":
-				slices.Sort(c.Debug)
-				c.Debug = slices.Compact(c.Debug)
-				row = append(row, strings.Join(c.Debug, ", "))
-			}
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
 		}
-
-		data = append(data, row)
 	}
-
-	return data
 }
 
-func generateMD(data [][]string, colHeaders map[string]string, stats stats, options options) string {
+// Column widths are measured over all rows first, then the table is written row by row
+func generateMD(w io.Writer, data []tableRow, totals tableTotals, footnotes []string, colHeaders map[string]string, stats stats, options options) {
 
 	headerFields := map[string][]string{}
-	if options.stats.table == true {
-		sumModels := 0
-		sumWB := 0
-		sumNP := 0
-		makerNext := ""
-		rowsTotal := len(data)
-
-		for i, r := range data {
-			maker := r[1]
-			if i != rowsTotal-1 {
-				makerNext = data[i+1][1]
-			}
-
-			for j, f := range r {
-				if j == 0 || j == 1 {
-					continue
-				}
-				switch options.fields[j-2] {
-				case "model":
-					sumModels += 1
-				case "wbpresets":
-					if f == options.bools[0] {
-						sumWB += 1
-					}
-				case "noiseprofiles":
-					if f == options.bools[0] {
-						sumNP += 1
-					}
-				}
-			}
-
-			if i == rowsTotal-1 || (options.segments != 0 && maker != makerNext) {
-				percentWB := int(math.Round(float64(sumWB) / float64(sumModels) * 100))
-				percentNP := int(math.Round(float64(sumNP) / float64(sumModels) * 100))
-
-				hf := make([]string, 0, len(options.fields))
-				for _, f := range options.fields {
-					switch f {
-					case "model":
-						hf = append(hf, fmt.Sprintf(options.thFormatStr[0], colHeaders[f], sumModels))
-					case "wbpresets":
-						hf = append(hf, fmt.Sprintf(options.thFormatStr[1], colHeaders[f], sumWB, percentWB))
-					case "noiseprofiles":
-						hf = append(hf, fmt.Sprintf(options.thFormatStr[1], colHeaders[f], sumNP, percentNP))
-					default:
-						hf = append(hf, colHeaders[f])
-					}
-					if options.segments == 0 {
-						headerFields["fulltable"] = hf
-					} else {
-						headerFields[maker] = hf
-					}
-				}
+	footerFields := map[string][]string{}
 
-				sumModels = 0
-				sumWB = 0
-				sumNP = 0
+	if options.stats.table == true {
+		if options.segments == 0 {
+			headerFields["fulltable"] = statsHeader(totals.all, colHeaders, options)
+		} else {
+			for maker, t := range totals.makers {
+				headerFields[maker] = statsHeader(t, colHeaders, options)
 			}
 		}
 	} else { // No stats
@@ -736,22 +3229,47 @@ func generateMD(data [][]string, colHeaders map[string]string, stats stats, opti
 		headerFields["nostats"] = hf
 	}
 
+	if options.footer == true {
+		if options.segments != 0 {
+			for maker, t := range totals.makers {
+				footerFields[maker] = footerRow("Total", t, options)
+			}
+		}
+		if options.segments == 0 {
+			footerFields["fulltable"] = footerRow("Grand total", totals.all, options)
+		}
+	}
+
 	// Calculate the widest field in each column, so table cells line up nicely
 	colWidths := make([]int, len(options.fields))
 	for _, h := range headerFields {
 		for i, f := range h {
-			w := len(f)
-			if w > colWidths[i] {
-				colWidths[i] = w
+			width := utf8.RuneCountInString(f)
+			if width > colWidths[i] {
+				colWidths[i] = width
+			}
+		}
+	}
+	for _, h := range footerFields {
+		for i, f := range h {
+			width := utf8.RuneCountInString(f)
+			if width > colWidths[i] {
+				colWidths[i] = width
 			}
 		}
 	}
 	for _, r := range data {
-		// We skip the first two fields, since they are not in the output
-		for i, f := range r[2:] {
-			w := len(f)
-			if w > colWidths[i] {
-				colWidths[i] = w
+		for i, f := range r.fields {
+			width := utf8.RuneCountInString(f)
+			if options.wrapAliases > 0 && options.fields[i] == "aliases" {
+				// Wrapped cells are as wide as their longest line
+				width = 0
+				for _, l := range strings.Split(f, "<br>") {
+					width = max(width, utf8.RuneCountInString(l))
+				}
+			}
+			if width > colWidths[i] {
+				colWidths[i] = width
 			}
 		}
 	}
@@ -763,46 +3281,393 @@ func generateMD(data [][]string, colHeaders map[string]string, stats stats, opti
 	}
 	tRowSep := constructTableRow(sep, colWidths)
 
-	// Build the table
-	mdTable := strings.Builder{}
-
+	// Write the table
 	hLevel := strings.Repeat("#", options.segments)
 
 	if options.stats.text == true {
 		t := fmt.Sprintf("In total **%v** cameras are supported, of which **%v (%v%%)** have white balance presets and **%v (%v%%)** have noise profiles.\n\n",
-			stats.supported, stats.wbPresets, stats.wbPresetsPercent, stats.noiseProfiles, stats.noiseProfilePercent)
-		mdTable.WriteString(t)
+			stats.supported, stats.wbPresets, formatPercent(stats.wbPresetsPercent, options), stats.noiseProfiles, formatPercent(stats.noiseProfilePercent, options))
+		io.WriteString(w, t)
+	}
+
+	if options.legend == "before" {
+		generateLegend(w, data, options)
 	}
 
 	makerPrev := ""
 	for i, r := range data {
-		maker := r[1]
+		maker := r.maker
 
 		if i == 0 && options.segments == 0 { // Table header
 			if options.stats.table == true {
-				mdTable.WriteString(constructTableRow(headerFields["fulltable"], colWidths))
+				io.WriteString(w, constructTableRow(headerFields["fulltable"], colWidths))
 			} else {
-				mdTable.WriteString(constructTableRow(headerFields["nostats"], colWidths))
+				io.WriteString(w, constructTableRow(headerFields["nostats"], colWidths))
 			}
-			mdTable.WriteString(tRowSep)
+			io.WriteString(w, tRowSep)
 		}
 
 		if options.segments != 0 && maker != makerPrev { // Segment header
-			mdTable.WriteString(fmt.Sprintf("\n%s %s\n\n", hLevel, maker))
+			fmt.Fprintf(w, "\n%s %s\n\n", hLevel, maker)
 			if options.stats.table == true {
-				mdTable.WriteString(constructTableRow(headerFields[maker], colWidths))
+				io.WriteString(w, constructTableRow(headerFields[maker], colWidths))
 			} else {
-				mdTable.WriteString(constructTableRow(headerFields["nostats"], colWidths))
+				io.WriteString(w, constructTableRow(headerFields["nostats"], colWidths))
 			}
-			mdTable.WriteString(tRowSep)
+			io.WriteString(w, tRowSep)
 		}
 
-		mdTable.WriteString(constructTableRow(r[2:], colWidths))
+		io.WriteString(w, constructTableRow(r.fields, colWidths))
+
+		if options.footer == true && options.segments != 0 && (i == len(data)-1 || data[i+1].maker != maker) { // Segment footer
+			io.WriteString(w, constructTableRow(footerFields[maker], colWidths))
+		}
 
 		makerPrev = maker
 	}
 
-	return mdTable.String()
+	if options.footer == true && len(data) > 0 && options.segments == 0 {
+		io.WriteString(w, constructTableRow(footerFields["fulltable"], colWidths))
+	} else if options.footer == true && len(data) > 0 {
+		// A table of its own after the segments, sized separately so it doesn't widen them
+		totalHeader := headerFields["nostats"]
+		if options.stats.table == true {
+			totalHeader = statsHeader(totals.all, colHeaders, options)
+		}
+		totalRow := footerRow("Grand total", totals.all, options)
+		totalWidths := make([]int, len(options.fields))
+		totalSep := make([]string, 0, len(options.fields))
+		for i := range totalWidths {
+			totalWidths[i] = max(utf8.RuneCountInString(totalHeader[i]), utf8.RuneCountInString(totalRow[i]), 1)
+			totalSep = append(totalSep, strings.Repeat("-", totalWidths[i]))
+		}
+		fmt.Fprintf(w, "\n%s Grand total\n\n", hLevel)
+		io.WriteString(w, constructTableRow(totalHeader, totalWidths))
+		io.WriteString(w, constructTableRow(totalSep, totalWidths))
+		io.WriteString(w, constructTableRow(totalRow, totalWidths))
+	}
+
+	if options.legend == "after" {
+		io.WriteString(w, "\n")
+		generateLegend(w, data, options)
+	}
+
+	if slices.Contains(options.fields, "notes") && len(footnotes) > 0 {
+		io.WriteString(w, "\n")
+		for i, n := range footnotes {
+			fmt.Fprintf(w, "[^%v]: %v\n", i+1, n)
+		}
+	}
+}
+
+var decoderDescriptions = map[string]string{
+	"RawSpeed": "Supported by RawSpeed",
+	"Partial":  "Partially supported by RawSpeed",
+	"LibRaw":   "Supported by LibRaw",
+	"Unknown":  "Support status unknown",
+	"":         "Not supported",
+}
+
+// Explains custom -bools values if a boolean column is shown, and the decoders present in the table
+func generateLegend(w io.Writer, data []tableRow, options options) {
+	legend := []string{}
+
+	if !slices.Equal(options.bools, []string{"Yes", "No"}) && slices.ContainsFunc(options.fields, func(f string) bool { return f == "wbpresets" || f == "noiseprofiles" || f == "isalias" || f == "isnew" }) {
+		for i, meaning := range []string{"Yes", "No"} {
+			if options.bools[i] != "" { // An empty cell needs no explanation
+				legend = append(legend, fmt.Sprintf("`%v`: %v", options.bools[i], meaning))
+			}
+		}
+	}
+
+	if i := slices.Index(options.fields, "formats"); i != -1 && slices.ContainsFunc(data, func(r tableRow) bool { return strings.Contains(r.fields[i], "*") }) {
+		legend = append(legend, "`*` after a format: Experimental")
+	}
+
+	if i := slices.Index(options.fields, "decoder"); i != -1 {
+		decoders := []string{}
+		for _, r := range data {
+			decoders = appendUnique(decoders, r.fields[i])
+		}
+		slices.Sort(decoders)
+		for _, d := range decoders {
+			raw := d
+			for k, label := range options.decoderLabels {
+				if label == d {
+					raw = strings.ToLower(k)
+				}
+			}
+			description, ok := "", false
+			for k, v := range decoderDescriptions {
+				if strings.EqualFold(k, raw) {
+					description, ok = v, true
+				}
+			}
+			if d == options.emptyPlaceholder {
+				description, ok = decoderDescriptions[""], true
+			}
+			if !ok {
+				description = "Decoder set in cameras.xml"
+			}
+			if d == "" {
+				d = "(empty)"
+			}
+			legend = append(legend, fmt.Sprintf("`%v`: %v", d, description))
+		}
+	}
+
+	if len(legend) == 0 {
+		return
+	}
+	io.WriteString(w, "**Legend**\n\n")
+	for _, l := range legend {
+		fmt.Fprintf(w, "- %v\n", l)
+	}
+	io.WriteString(w, "\n")
+}
+
+type columnTotals struct {
+	models        int
+	wbPresets     int
+	noiseProfiles int
+}
+
+func (t columnTotals) add(o columnTotals) columnTotals {
+	return columnTotals{t.models + o.models, t.wbPresets + o.wbPresets, t.noiseProfiles + o.noiseProfiles}
+}
+
+// Output row, with the camera key and maker whether or not they are fields
+type tableRow struct {
+	key    string
+	maker  string // For segments
+	fields []string
+}
+
+// Totals of the output rows, per maker and for all rows.
+// Counted from the cameras rather than the rendered fields, so they don't depend on -bools
+type tableTotals struct {
+	makers map[string]columnTotals
+	all    columnTotals
+}
+
+func statsHeader(t columnTotals, colHeaders map[string]string, options options) []string {
+	percentWB := formatPercent(percentage(t.wbPresets, t.models, options), options)
+	percentNP := formatPercent(percentage(t.noiseProfiles, t.models, options), options)
+
+	hf := make([]string, 0, len(options.fields))
+	for _, f := range options.fields {
+		switch f {
+		case "model":
+			hf = append(hf, fmt.Sprintf(options.thFormatStr[0], colHeaders[f], t.models))
+		case "wbpresets":
+			hf = append(hf, fmt.Sprintf(options.thFormatStr[1], colHeaders[f], t.wbPresets, percentWB))
+		case "noiseprofiles":
+			hf = append(hf, fmt.Sprintf(options.thFormatStr[1], colHeaders[f], t.noiseProfiles, percentNP))
+		default:
+			hf = append(hf, colHeaders[f])
+		}
+	}
+	return hf
+}
+
+// Row with the column totals. The label goes in the first field, unless it holds a total
+func footerRow(label string, t columnTotals, options options) []string {
+	row := make([]string, 0, len(options.fields))
+	for _, f := range options.fields {
+		switch f {
+		case "model":
+			row = append(row, strconv.Itoa(t.models))
+		case "wbpresets":
+			row = append(row, strconv.Itoa(t.wbPresets))
+		case "noiseprofiles":
+			row = append(row, strconv.Itoa(t.noiseProfiles))
+		default:
+			row = append(row, "")
+		}
+	}
+	if len(row) > 0 && row[0] == "" {
+		row[0] = label
+	}
+	return row
+}
+
+// Simple table for -report, in the selected format
+func generateReport(w io.Writer, headers []string, rows [][]string, options options) {
+	switch options.format {
+	case "md":
+		colWidths := make([]int, len(headers))
+		for _, r := range append([][]string{headers}, rows...) {
+			for i, f := range r {
+				colWidths[i] = max(colWidths[i], utf8.RuneCountInString(f))
+			}
+		}
+
+		sep := make([]string, 0, len(colWidths))
+		for _, c := range colWidths {
+			sep = append(sep, strings.Repeat("-", c))
+		}
+
+		io.WriteString(w, constructTableRow(headers, colWidths))
+		io.WriteString(w, constructTableRow(sep, colWidths))
+		for _, r := range rows {
+			io.WriteString(w, constructTableRow(r, colWidths))
+		}
+	case "tsv":
+		fmt.Fprintf(w, "%v\n", strings.Join(headers, "\t"))
+		for _, r := range rows {
+			fmt.Fprintf(w, "%v\n", strings.Join(r, "\t"))
+		}
+	case "json", "ndjson":
+		objects := make([]jsonObject, 0, len(rows))
+		for _, r := range rows {
+			obj := make(jsonObject, 0, len(headers))
+			for i, h := range headers {
+				obj = append(obj, jsonField{strings.ToLower(h), r[i]})
+			}
+			objects = append(objects, obj)
+		}
+
+		writeJSONObjects(w, objects, options)
+	}
+}
+
+type jsonField struct {
+	key   string
+	value any
+}
+
+// JSON object that keeps its keys in order, so output is stable and follows -fields
+type jsonObject []jsonField
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	b.WriteString("{")
+	for i, f := range o {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteString(":")
+		b.Write(v)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// Unlike the tables, JSON uses native types and unescaped strings
+func jsonCamera(c camera, options options) jsonObject {
+	obj := make(jsonObject, 0, len(options.fields))
+	for _, f := range options.fields {
+		var v any
+		switch f {
+		case "maker":
+			v = c.Maker
+		case "model":
+			v = c.Model
+		case "aliases":
+			v = nonNil(c.Aliases)
+		case "formats":
+			if options.hideDefaultFormats == true && slices.Equal(c.Formats, []string{"default"}) {
+				v = []string{}
+			} else {
+				v = nonNil(c.Formats)
+			}
+		case "wbpresets":
+			v = c.WBPresets
+		case "noiseprofiles":
+			v = c.NoiseProfiles
+		case "rssupported":
+			v = c.RSSupported
+		case "supportstatus":
+			// A bool, except for partial support
+			switch c.SupportStatus {
+			case "true":
+				v = true
+			case "false":
+				v = false
+			case "":
+				v = nil
+			default:
+				v = c.SupportStatus
+			}
+		case "hints":
+			v = nonNil(c.Hints)
+		case "notes":
+			v = nonNil(c.Notes)
+		case "decoder":
+			v = decoderLabel(c.Decoder, options)
+		case "status":
+			v = statusLabel(c, options)
+		case "isalias":
+			v = c.IsAlias
+		case "isnew":
+			v = c.IsNew
+		case "noiseprofilesource":
+			v = nonNil(c.NoiseProfileSource)
+		case "sources":
+			v = nonNil(c.Sources)
+		case "debug":
+			debug := slices.Clone(c.Debug)
+			slices.Sort(debug)
+			v = nonNil(slices.Compact(debug))
+		}
+		if options.nullEmpty == true {
+			if s, ok := v.(string); ok && s == "" {
+				v = nil
+			} else if l, ok := v.([]string); ok && len(l) == 0 {
+				v = nil
+			}
+		}
+		obj = append(obj, jsonField{f, v})
+	}
+	return obj
+}
+
+func generateJSON(w io.Writer, cameras map[string]camera, options options) {
+	_, outCameras := selectOutputCameras(cameras, options)
+
+	objects := make([]jsonObject, 0, len(outCameras))
+	for _, c := range outCameras {
+		objects = append(objects, jsonCamera(c, options))
+	}
+
+	writeJSONObjects(w, objects, options)
+}
+
+// An indented array, or with -format ndjson one object per line
+func writeJSONObjects(w io.Writer, objects []jsonObject, options options) {
+	if options.format == "ndjson" {
+		for _, o := range objects {
+			data, err := json.Marshal(o)
+			if err != nil {
+				log.Fatal(err)
+			}
+			w.Write(append(data, '\n'))
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(objects, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Write(append(data, '\n'))
+}
+
+// So empty lists are [] rather than null in JSON
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
 }
 
 func constructTableRow(fields []string, colWidths []int) string {
@@ -817,19 +3682,183 @@ func constructTableRow(fields []string, colWidths []int) string {
 	return tableRow.String()
 }
 
-func generateTSV(data [][]string, colHeaders map[string]string, options options) string {
+// With -annotate, each row follows a comment with its camera key and sources, looked up in cameras
+func generateTSV(w io.Writer, data []tableRow, totals tableTotals, cameras map[string]camera, colHeaders map[string]string, options options) {
 	headers := make([]string, 0, len(options.fields))
 	for _, f := range options.fields {
 		headers = append(headers, colHeaders[f])
 	}
 
-	tsvData := strings.Builder{}
-	tsvData.WriteString(fmt.Sprintf("%v\n", strings.Join(headers, "\t")))
+	fmt.Fprintf(w, "%v\n", strings.Join(headers, "\t"))
 	for _, r := range data {
-		tsvData.WriteString(fmt.Sprintf("%v\n", strings.Join(r[2:], "\t")))
+		if options.annotate == true {
+			fmt.Fprintf(w, "# key: %v; sources: %v\n", r.key, strings.Join(cameras[r.key].Sources, ", "))
+		}
+		fmt.Fprintf(w, "%v\n", strings.Join(r.fields, "\t"))
+	}
+
+	if options.footer == true {
+		fmt.Fprintf(w, "%v\n", strings.Join(footerRow("Total", totals.all, options), "\t"))
+	}
+}
+
+// encoding/json sorts map keys, so the file only changes when the counts do
+func writeMakerCounts(cameras map[string]camera, options options) {
+	data, err := json.MarshalIndent(countMakers(cameras, options), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(options.makerCounts, append(data, '\n'), options.outputMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Lists the output cameras for the website, which links to them by anchor
+func writeManifest(cameras map[string]camera, options options) {
+	_, outCameras := selectOutputCameras(cameras, options)
+
+	entries := make([]jsonObject, 0, len(outCameras))
+	for _, c := range outCameras {
+		entries = append(entries, jsonObject{
+			{"maker", c.Maker},
+			{"model", c.Model},
+			{"anchor", slugify(c.Maker + " " + c.Model)},
+			{"decoder", c.Decoder},
+		})
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(options.manifest, append(data, '\n'), options.outputMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// GitHub appends -1, -2, ... to repeated heading anchors, so a repeated anchor links to the wrong place.
+// Reports empty and repeated anchors of -segments headings and -manifest entries
+func checkAnchors(cameras map[string]camera, options options) []string {
+	problems := []string{}
+	check := func(kind string, names []string) {
+		seen := map[string]string{}
+		for _, n := range names {
+			anchor := slugify(n)
+			if anchor == "" {
+				problems = append(problems, fmt.Sprintf("%v %q has an empty anchor", kind, n))
+			} else if prev, ok := seen[anchor]; ok {
+				problems = append(problems, fmt.Sprintf("%v %q and %q have the same anchor %q", kind, prev, n, anchor))
+			} else {
+				seen[anchor] = n
+			}
+		}
 	}
 
-	return tsvData.String()
+	_, outCameras := selectOutputCameras(cameras, options)
+	if options.segments != 0 {
+		makers := []string{}
+		for _, c := range outCameras {
+			makers = appendUnique(makers, c.Maker)
+		}
+		check("Heading", makers)
+	}
+	if options.manifest != "" {
+		names := make([]string, 0, len(outCameras))
+		for _, c := range outCameras {
+			names = append(names, c.Maker+" "+c.Model)
+		}
+		check("Manifest entry", names)
+	}
+	return problems
+}
+
+// Anchor in the style GitHub uses for headings: lower case, punctuation removed, spaces as hyphens
+func slugify(s string) string {
+	slug := strings.Builder{}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			slug.WriteRune(r)
+		case r == ' ':
+			slug.WriteRune('-')
+		}
+	}
+	return slug.String()
+}
+
+func writeSummary(start time.Time, stats stats, options options) {
+	summary := runSummary{
+		Started:  start,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+		Sources:  sourceLog,
+		Counts: map[string]int{
+			"cameras":                   stats.cameras,
+			"noMaker":                   stats.noMaker,
+			"aliases":                   stats.aliases,
+			"marketedTotal":             stats.marketedTotal,
+			"rawspeed":                  stats.rawspeed,
+			"libraw":                    stats.libraw,
+			"partial":                   stats.partial,
+			"supported":                 stats.supported,
+			"unknown":                   stats.unknown,
+			"unsupported":               stats.unsupported,
+			"wbPresets":                 stats.wbPresets,
+			"noiseProfiles":             stats.noiseProfiles,
+			"calibrationWithoutDecoder": stats.calibrationWithoutDecoder,
+		},
+		Warnings: warningCounts,
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(options.summary, append(data, '\n'), options.outputMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func appendUnique(s []string, v string) []string {
+	if slices.Contains(s, v) {
+		return s
+	}
+	return append(s, v)
+}
+
+// Sorts and removes case-insensitive duplicates
+// Aliases of a camera split across several <Camera> elements are appended per element,
+// so case variants can be anywhere in the slice. Sorting case-insensitively makes them adjacent,
+// then the result is sorted byte-wise as the output always was, e.g. "IXY 650" before "Ixus 285"
+func dedupAliases(aliases []string) []string {
+	slices.SortFunc(aliases, func(a, b string) int {
+		if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(b, a) // Ensure ALL CAPS aliases get removed by slices.CompactFunc
+	})
+	aliases = slices.CompactFunc(aliases, strings.EqualFold)
+	slices.Sort(aliases)
+	return aliases
+}
+
+// Case-insensitive, trying each maker in turn
+func stripMakerPrefix(s string, makers ...string) string {
+	for _, m := range makers {
+		if m != "" && len(s) > len(m) && strings.EqualFold(s[:len(m)+1], m+" ") {
+			return s[len(m)+1:]
+		}
+	}
+	return s
+}
+
+// Maps can't be sorted, so use a separate sorted slice for the output order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func cameraKey(maker string, model string) string {
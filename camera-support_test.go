@@ -0,0 +1,802 @@
+/*
+   This file is part of darktable,
+   Copyright (C) 2009-2025 darktable developers.
+
+   darktable is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   darktable is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with darktable.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetDataBOM(t *testing.T) {
+	var v map[string]any
+	if err := json.Unmarshal(getData("testdata/bom.json", 0), &v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v["wb_presets"]; !ok {
+		t.Errorf("got %v, want a wb_presets key", v)
+	}
+}
+
+func TestExpandArgsFiles(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("fields", "", "")
+	flags.Bool("unknown", false, "")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"@testdata/args.txt", "out.md"}, []string{"-format", "tsv", "-unknown", "out.md"}},
+		{[]string{"-fields", "@website"}, []string{"-fields", "@website"}},
+		{[]string{"-fields=@website"}, []string{"-fields=@website"}},
+		{[]string{"--fields", "@website"}, []string{"--fields", "@website"}},
+		{[]string{"-unknown", "@testdata/args.txt"}, []string{"-unknown", "-format", "tsv", "-unknown"}},
+	}
+	for _, tt := range tests {
+		if got := expandArgsFiles(flags, tt.args); !slices.Equal(got, tt.want) {
+			t.Errorf("expandArgsFiles(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFormatsDecoder(t *testing.T) {
+	problems = nil
+	cameras := map[string]camera{}
+	supportedModes := loadRawSpeed(cameras, options{rawspeedPath: "testdata/cameras-formats-no-decoder.xml"})
+	checkFormatsDecoder(cameras, supportedModes)
+
+	if len(problems) != 1 || problems[0].Category != "formats-no-decoder" || problems[0].Model != "Bar" {
+		t.Errorf("problems = %+v, want one formats-no-decoder for Foo Bar", problems)
+	}
+
+	problems = nil
+	cameras = map[string]camera{}
+	supportedModes = loadRawSpeed(cameras, options{rawspeedPath: "testdata/cameras-formats-no-decoder.xml", partial: true})
+	checkFormatsDecoder(cameras, supportedModes)
+
+	if len(problems) != 0 {
+		t.Errorf("with -partial, problems = %+v, want none", problems)
+	}
+}
+
+// getData exits on a failed download, so each case runs in a subprocess
+func TestGetDataTruncated(t *testing.T) {
+	if name := os.Getenv("GETDATA_TRUNCATED"); name != "" {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if name == "content-length" {
+				w.Header().Set("Content-Length", "1000")
+				w.Write([]byte("<Cameras>"))
+				w.(http.Flusher).Flush()
+				// Drop the connection before the rest of the body
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.Write([]byte("<Cameras>"))
+			w.(http.Flusher).Flush()
+		}))
+		defer srv.Close()
+		httpClient = srv.Client()
+		getData(srv.URL, 100)
+		return
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"content-length", "expected 1000 bytes, got 9"},
+		{"chunked", "expected at least 100"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestGetDataTruncated$")
+		cmd.Env = append(os.Environ(), "GETDATA_TRUNCATED="+tt.name)
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			t.Errorf("%v: getData succeeded, want an error", tt.name)
+		} else if !strings.Contains(string(out), tt.want) {
+			t.Errorf("%v: output %q doesn't contain %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+// Cameras keyed like the loaders key them
+func camerasOf(cs ...camera) map[string]camera {
+	cameras := map[string]camera{}
+	for _, c := range cs {
+		cameras[cameraKey(c.Maker, c.Model)] = c
+	}
+	return cameras
+}
+
+// About the size of the full camera set
+func benchmarkCameras() map[string]camera {
+	cs := make([]camera, 0, 2000)
+	for i := range 2000 {
+		cs = append(cs, camera{
+			Maker:         fmt.Sprintf("Maker %v", i%40),
+			Model:         fmt.Sprintf("Model %v", i),
+			Aliases:       []string{fmt.Sprintf("Alias %v", i)},
+			Formats:       []string{"default"},
+			WBPresets:     i%2 == 0,
+			NoiseProfiles: i%3 == 0,
+			Decoder:       "RawSpeed",
+		})
+	}
+	return camerasOf(cs...)
+}
+
+func BenchmarkGenerateMD(b *testing.B) {
+	cameras := benchmarkCameras()
+	options := defaultOptions()
+	options.stats.table = true
+	colHeaders := defaultColumnHeaders()
+	stats := generateStats(cameras, options)
+	b.ReportAllocs()
+	for range b.N {
+		data, totals, footnotes := prepareOutputData(cameras, options)
+		generateMD(io.Discard, data, totals, footnotes, colHeaders, stats, options)
+	}
+}
+
+func BenchmarkGenerateTSV(b *testing.B) {
+	cameras := benchmarkCameras()
+	options := defaultOptions()
+	options.format = "tsv"
+	colHeaders := defaultColumnHeaders()
+	b.ReportAllocs()
+	for range b.N {
+		data, totals, _ := prepareOutputData(cameras, options)
+		generateTSV(io.Discard, data, totals, cameras, colHeaders, options)
+	}
+}
+
+func TestStatsTableBools(t *testing.T) {
+	cameras := camerasOf(
+		camera{Maker: "Canon", Model: "1", Aliases: []string{"1"}, WBPresets: true, Decoder: "RawSpeed"},
+		camera{Maker: "Canon", Model: "0", Aliases: []string{"0"}, NoiseProfiles: true, Decoder: "LibRaw"},
+		camera{Maker: "Nikon", Model: "Z 1", WBPresets: true, NoiseProfiles: true, Decoder: "RawSpeed"},
+	)
+	options := defaultOptions()
+	options.bools = []string{"1", "0"}
+	options.stats.table = true
+
+	out := &strings.Builder{}
+	data, totals, footnotes := prepareOutputData(cameras, options)
+	generateMD(out, data, totals, footnotes, defaultColumnHeaders(), generateStats(cameras, options), options)
+	header, _, _ := strings.Cut(out.String(), "\n")
+	for _, want := range []string{"Model (3)", "WB Presets (2 / 67%)", "Noise Profile (2 / 67%)"} {
+		if !strings.Contains(header, want) {
+			t.Errorf("header %q doesn't contain %q", header, want)
+		}
+	}
+}
+
+func TestGetDataArchiveMember(t *testing.T) {
+	want, err := os.ReadFile("testdata/archive-member.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"testdata/archive.tar.gz#darktable/data/cameras.xml", "testdata/archive.zip#./darktable/data/cameras.xml"} {
+		if got := getData(path, 0); !bytes.Equal(got, want) {
+			t.Errorf("getData(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestGenerateJSONStable(t *testing.T) {
+	cameras := benchmarkCameras()
+	options := defaultOptions()
+	options.format = "json"
+	options.fields = []string{"decoder", "model", "maker", "aliases", "wbpresets"}
+
+	first := &bytes.Buffer{}
+	generateJSON(first, cameras, options)
+	for range 5 {
+		again := &bytes.Buffer{}
+		generateJSON(again, cameras, options)
+		if !bytes.Equal(again.Bytes(), first.Bytes()) {
+			t.Fatal("output differs between runs")
+		}
+	}
+
+	want := `"decoder": "RawSpeed",
+    "model": "Model 0",
+    "maker": "Maker 0",`
+	if !strings.Contains(first.String(), want) {
+		t.Errorf("keys not in -fields order, want %q in\n%.300s", want, first.String())
+	}
+}
+
+func TestLoadRawSpeedHints(t *testing.T) {
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options{rawspeedPath: "testdata/cameras-hints.xml"})
+
+	if got, want := cameras[cameraKey("Canon", "EOS R5")].Hints, []string{"force_uncompressed", "swapped_wide_tele"}; !slices.Equal(got, want) {
+		t.Errorf("EOS R5 hints = %q, want %q", got, want)
+	}
+	if got := cameras[cameraKey("Sony", "ILCE-7M3")].Hints; len(got) != 0 {
+		t.Errorf("ILCE-7M3 hints = %q, want none", got)
+	}
+}
+
+func TestMergeEquivalents(t *testing.T) {
+	cameras := map[string]camera{
+		cameraKey("Sony", "ILCE-7M3"): {Maker: "Sony", Model: "ILCE-7M3", Aliases: []string{"ILCE-7M3K"}, Decoder: "RawSpeed"},
+		cameraKey("Sony", "A7 III"):   {Maker: "Sony", Model: "A7 III", NoiseProfiles: true},
+	}
+	mergeEquivalents(cameras, options{equivalentsPath: "testdata/equivalents.csv"})
+
+	if len(cameras) != 1 {
+		t.Fatalf("got %v cameras, want 1", len(cameras))
+	}
+	c := cameras[cameraKey("Sony", "ILCE-7M3")]
+	if want := []string{"A7 III", "ILCE-7M3K"}; !slices.Equal(c.Aliases, want) {
+		t.Errorf("aliases = %q, want %q", c.Aliases, want)
+	}
+	if c.Decoder != "RawSpeed" || c.NoiseProfiles != true {
+		t.Errorf("decoder = %q, noise profiles = %v, want RawSpeed and true", c.Decoder, c.NoiseProfiles)
+	}
+	if !slices.Contains(c.Debug, "equivalents: Merged A7 III") {
+		t.Errorf("debug = %q, want the merge recorded", c.Debug)
+	}
+}
+
+func TestStatsPrecision(t *testing.T) {
+	tests := []struct {
+		precision int
+		want      string
+	}{
+		{0, "94"},
+		{1, "94.3"},
+	}
+	for _, tt := range tests {
+		options := defaultOptions()
+		options.statsPrecision = tt.precision
+		if got := formatPercent(percentage(943, 1000, options), options); got != tt.want {
+			t.Errorf("precision %v: got %v, want %v", tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRawSpeedDecoderAttribute(t *testing.T) {
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options{rawspeedPath: "testdata/cameras-decoder.xml"})
+
+	tests := []struct {
+		maker, model string
+		decoder      string
+		debug        string
+	}{
+		{"Canon", "EOS R5", "LibRaw", "cameras.xml: Decoder from decoder attribute"},
+		{"Sony", "ILCE-7M3", "RawSpeed", ""},
+		{"Foo", "Bar", "Unknown", "cameras.xml: Unrecognised decoder attribute weird"},
+	}
+	for _, tt := range tests {
+		c := cameras[cameraKey(tt.maker, tt.model)]
+		if c.Decoder != tt.decoder {
+			t.Errorf("%v %v: decoder = %q, want %q", tt.maker, tt.model, c.Decoder, tt.decoder)
+		}
+		if tt.debug != "" && !slices.Contains(c.Debug, tt.debug) {
+			t.Errorf("%v %v: debug = %q, want %q", tt.maker, tt.model, c.Debug, tt.debug)
+		}
+	}
+}
+
+func TestLoadCalibration(t *testing.T) {
+	cameras := map[string]camera{
+		cameraKey("Canon", "EOS R5"): {Maker: "Canon", Model: "EOS R5", Decoder: "RawSpeed"},
+	}
+	options := options{wbpresetsPath: "testdata/wb_presets.json", noiseprofilesPath: "testdata/noiseprofiles.json"}
+	loadWBPresets(cameras, options)
+	loadNoiseProfiles(cameras, options)
+
+	tests := []struct {
+		maker, model  string
+		wbPresets     bool
+		noiseProfiles bool
+		decoder       string
+		sources       []string
+	}{
+		{"Canon", "EOS R5", true, true, "RawSpeed", []string{"wbpresets", "noiseprofiles"}},
+		{"Canon", "EOS R6", true, false, "Unknown", []string{"wbpresets"}},
+		{"Sony", "ILCE-7M3", false, true, "Unknown", []string{"noiseprofiles"}},
+	}
+	for _, tt := range tests {
+		c := cameras[cameraKey(tt.maker, tt.model)]
+		if c.WBPresets != tt.wbPresets || c.NoiseProfiles != tt.noiseProfiles || c.Decoder != tt.decoder || !slices.Equal(c.Sources, tt.sources) {
+			t.Errorf("%v: got %v, %v, %q, %q, want %v, %v, %q, %q", tt.model, c.WBPresets, c.NoiseProfiles, c.Decoder, c.Sources, tt.wbPresets, tt.noiseProfiles, tt.decoder, tt.sources)
+		}
+	}
+}
+
+func TestLoadLibRawDuplicateAlias(t *testing.T) {
+	problems = nil
+	cameras := map[string]camera{
+		cameraKey("Canon", "EOS R5"): {Maker: "Canon", Model: "EOS R5", Aliases: []string{"EOS R5 C"}},
+	}
+	loadLibRaw(cameras, options{librawPath: "testdata/imageio_libraw.c"})
+
+	c := cameras[cameraKey("Canon", "EOS R5")]
+	if want := []string{"EOS R5 C"}; !slices.Equal(c.Aliases, want) {
+		t.Errorf("EOS R5 aliases = %q, want %q", c.Aliases, want)
+	}
+	if !slices.Contains(c.Debug, "imageio_libraw.c: Skipped duplicate alias eos r5 c") {
+		t.Errorf("EOS R5 debug = %q, want the skipped alias recorded", c.Debug)
+	}
+	if got, want := cameras[cameraKey("Sony", "DSC-RX100M3")].Aliases, []string{"RX100 III"}; !slices.Equal(got, want) {
+		t.Errorf("DSC-RX100M3 aliases = %q, want %q", got, want)
+	}
+}
+
+func TestBucketEmptyMakers(t *testing.T) {
+	problems = nil
+	options := options{rawspeedPath: "testdata/cameras-empty-maker.xml", wbpresetsPath: "testdata/wb_presets-empty-maker.json"}
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options)
+	loadWBPresets(cameras, options)
+	bucketEmptyMakers(cameras)
+
+	// Both sources' entries end up in one camera
+	c, ok := cameras[cameraKey(unknownMaker, "Mystery 1")]
+	if !ok || len(cameras) != 2 {
+		t.Fatalf("Mystery 1 not in the %v bucket: %+v", unknownMaker, cameras)
+	}
+	if c.Decoder != "RawSpeed" || c.WBPresets != true || !slices.Equal(c.Aliases, []string{"Mystery One"}) || len(c.Sources) != 2 {
+		t.Errorf("Mystery 1 = %+v, want the cameras.xml and wb_presets.json data merged", c)
+	}
+	if len(problems) != 2 || problems[0].Category != "empty-maker" || problems[1].Category != "empty-maker" {
+		t.Errorf("problems = %+v, want two empty-maker", problems)
+	}
+	if stats := generateStats(cameras, defaultOptions()); stats.noMaker != 1 {
+		t.Errorf("noMaker = %v, want 1", stats.noMaker)
+	}
+}
+
+func TestPartialDecoder(t *testing.T) {
+	options := defaultOptions()
+	options.rawspeedPath = "testdata/cameras-partial.xml"
+	options.partial = true
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options)
+
+	for model, want := range map[string]string{"ILCE-7M3": "RawSpeed", "ILCE-7M4": "Partial", "ILCE-7M5": ""} {
+		if got := cameras[cameraKey("Sony", model)].Decoder; got != want {
+			t.Errorf("%v: decoder = %q, want %q", model, got, want)
+		}
+	}
+
+	stats := generateStats(cameras, options)
+	if stats.cameras != 2 || stats.partial != 1 || stats.partialPercent != 50 || stats.supported != 1 {
+		t.Errorf("got %v cameras, %v partial (%v%%), %v supported, want 2, 1 (50%%), 1", stats.cameras, stats.partial, stats.partialPercent, stats.supported)
+	}
+}
+
+func TestReadRawSpeedDNGDelimiter(t *testing.T) {
+	options := defaultOptions()
+	options.rawspeedDNGPath = "testdata/rawspeed-dng-semicolon.csv"
+	options.dngDelimiter = ';'
+
+	want := []dngCamera{{Maker: "DJI", Model: "FC220"}, {Maker: "Pentax", Model: "K-1"}}
+	if got := readRawSpeedDNG(options); !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadRawSpeedSplitAliases(t *testing.T) {
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options{rawspeedPath: "testdata/cameras-split-aliases.xml"})
+
+	c := cameras[cameraKey("Panasonic", "DC-G9")]
+	// Of two case variants the one with more lower case letters is kept. An alias repeating the model stays
+	if want := []string{"DC-G9", "DC-G95", "dc-g90", "g9"}; !slices.Equal(c.Aliases, want) {
+		t.Errorf("aliases = %q, want %q", c.Aliases, want)
+	}
+}
+
+func TestLoadLibRawMissingAlias(t *testing.T) {
+	cameras := map[string]camera{}
+	loadLibRaw(cameras, options{librawPath: "testdata/imageio_libraw-no-alias.c"})
+
+	if got := cameras[cameraKey("Sony", "DSC-RX100M4")].Aliases; len(got) != 0 {
+		t.Errorf("DSC-RX100M4 aliases = %q, want none", got)
+	}
+	if got, want := cameras[cameraKey("Sony", "DSC-RX100M3")].Aliases, []string{"RX100 III"}; !slices.Equal(got, want) {
+		t.Errorf("DSC-RX100M3 aliases = %q, want %q", got, want)
+	}
+}
+
+func TestCheckAnchors(t *testing.T) {
+	cameras := camerasOf(
+		camera{Maker: "Phase One", Model: "IQ180", Decoder: "RawSpeed"},
+		camera{Maker: "Phase-One", Model: "IQ250", Decoder: "RawSpeed"},
+		camera{Maker: "???", Model: "X", Decoder: "RawSpeed"},
+		camera{Maker: "Sony", Model: "ILCE-7M3", Decoder: "RawSpeed"},
+	)
+	options := defaultOptions()
+	options.segments = 2
+
+	want := []string{
+		`Heading "???" has an empty anchor`,
+		`Heading "Phase One" and "Phase-One" have the same anchor "phase-one"`,
+	}
+	if got := checkAnchors(cameras, options); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSortRowsWithinSegments(t *testing.T) {
+	cameras := camerasOf(
+		camera{Maker: "Canon", Model: "EOS R5", Decoder: "RawSpeed"},
+		camera{Maker: "Canon", Model: "EOS R6", Decoder: "LibRaw"},
+		camera{Maker: "Nikon", Model: "Z 8", Decoder: "RawSpeed"},
+		camera{Maker: "Sony", Model: "ILCE-7M3", Decoder: "RawSpeed"},
+		camera{Maker: "Sony", Model: "ILCE-7M4", Decoder: "LibRaw"},
+	)
+	options := defaultOptions()
+	options.segments = 2
+	options.sort = "decoder"
+
+	_, outCameras := selectOutputCameras(cameras, options)
+	got := []string{}
+	for _, c := range outCameras {
+		got = append(got, c.Model)
+	}
+	if want := []string{"EOS R6", "EOS R5", "Z 8", "ILCE-7M4", "ILCE-7M3"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFindNearDuplicates(t *testing.T) {
+	for _, merge := range []bool{false, true} {
+		problems = nil
+		options := defaultOptions()
+		options.rawspeedPath = "testdata/cameras-partial.xml"
+		options.noiseprofilesPath = "testdata/noiseprofiles-near-duplicate.json"
+		options.mergeNearDuplicates = merge
+		cameras := map[string]camera{}
+		loadRawSpeed(cameras, options)
+		loadNoiseProfiles(cameras, options)
+		findNearDuplicates(cameras, options)
+
+		if len(problems) != 1 || problems[0].Category != "near-duplicate" {
+			t.Errorf("merge %v: problems = %+v, want one near-duplicate", merge, problems)
+		}
+		if !merge {
+			if len(cameras) != 4 {
+				t.Errorf("merge %v: got %v cameras, want 4", merge, len(cameras))
+			}
+			continue
+		}
+		// Merged into the first in key order
+		c, ok := cameras[cameraKey("SONY", "ilce-7m3 ")]
+		if len(cameras) != 3 || !ok || c.Decoder != "RawSpeed" || c.NoiseProfiles != true {
+			t.Errorf("merge %v: got %+v, want 3 cameras with SONY ilce-7m3 decoded by RawSpeed and with noise profiles", merge, cameras)
+		}
+	}
+}
+
+func TestWriteOutputGzip(t *testing.T) {
+	cameras := benchmarkCameras()
+	options := defaultOptions()
+	options.format = "tsv"
+	options.output = filepath.Join(t.TempDir(), "cameras.tsv.gz")
+	writeTable(cameras, defaultColumnHeaders(), generateStats(cameras, options), options)
+
+	f, err := os.Open(options.output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &bytes.Buffer{}
+	data, totals, _ := prepareOutputData(cameras, options)
+	generateTSV(want, data, totals, cameras, defaultColumnHeaders(), options)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("decompressed output differs from the TSV, got %v bytes, want %v", len(got), want.Len())
+	}
+}
+
+func TestPercentageRounding(t *testing.T) {
+	tests := []struct {
+		rounding    string
+		part, total int
+		want        float64
+	}{
+		{"", 1, 8, 13},
+		{"half-up", 1, 8, 13},
+		{"half-up", 3, 8, 38},
+		{"half-even", 1, 8, 12},
+		{"half-even", 3, 8, 38},
+		{"floor", 3, 8, 37},
+		{"ceil", 1, 8, 13},
+		{"ceil", 1, 200, 1},
+		{"floor", 1, 200, 0},
+	}
+	for _, tt := range tests {
+		options := defaultOptions()
+		options.rounding = tt.rounding
+		if got := percentage(tt.part, tt.total, options); got != tt.want {
+			t.Errorf("%q: percentage(%v, %v) = %v, want %v", tt.rounding, tt.part, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestSegmentTotals(t *testing.T) {
+	cameras := benchmarkCameras()
+	options := defaultOptions()
+	options.segments = 2
+	options.stats.table = true
+	_, totals, _ := prepareOutputData(cameras, options)
+
+	sum := columnTotals{}
+	for _, m := range totals.makers {
+		sum = sum.add(m)
+	}
+	if sum != totals.all {
+		t.Errorf("segment totals add up to %+v, want %+v", sum, totals.all)
+	}
+	if stats := generateStats(cameras, options); totals.all.models != stats.cameras || totals.all.wbPresets != stats.wbPresets || totals.all.noiseProfiles != stats.noiseProfiles {
+		t.Errorf("table totals %+v differ from the stats, %v models, %v WB presets, %v noise profiles", totals.all, stats.cameras, stats.wbPresets, stats.noiseProfiles)
+	}
+}
+
+func TestLoadRawSpeedNamespaced(t *testing.T) {
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options{rawspeedPath: "testdata/cameras-namespaced.xml"})
+
+	if len(cameras) != 2 {
+		t.Fatalf("got %v cameras, want 2", len(cameras))
+	}
+	c := cameras[cameraKey("Sony", "ILCE-7M3")]
+	if c.Decoder != "RawSpeed" || !slices.Equal(c.Aliases, []string{"A7 III"}) {
+		t.Errorf("ILCE-7M3: decoder = %q, aliases = %q, want RawSpeed and A7 III", c.Decoder, c.Aliases)
+	}
+}
+
+func TestLoadAliasesExtra(t *testing.T) {
+	cameras := map[string]camera{
+		cameraKey("Sony", "ILCE-7M3"): {Maker: "Sony", Model: "ILCE-7M3", Aliases: []string{"A7 III"}},
+	}
+	loadAliasesExtra(cameras, options{aliasesExtraPath: "testdata/aliases-extra.json"})
+
+	c := cameras[cameraKey("Sony", "ILCE-7M3")]
+	if want := []string{"A7 III", "α7 III"}; !slices.Equal(c.Aliases, want) {
+		t.Errorf("aliases = %q, want %q", c.Aliases, want)
+	}
+	if want := []string{"aliases-extra: Added alias α7 III"}; !slices.Equal(c.Debug, want) {
+		t.Errorf("debug = %q, want %q", c.Debug, want)
+	}
+}
+
+func TestKeepFormats(t *testing.T) {
+	options := defaultOptions()
+	options.rawspeedPath = "testdata/cameras-hints.xml"
+	options.formatFilter = regexp.MustCompile(`^sRaw\d$`)
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options)
+	keepFormats(cameras, options)
+
+	if _, ok := cameras[cameraKey("Canon", "EOS R5")]; len(cameras) != 1 || !ok {
+		t.Errorf("got %+v, want only the EOS R5", cameras)
+	}
+	if stats := generateStats(cameras, options); stats.cameras != 1 {
+		t.Errorf("stats count %v cameras, want 1", stats.cameras)
+	}
+}
+
+func TestStatusField(t *testing.T) {
+	cameras := camerasOf(
+		camera{Maker: "Sony", Model: "ILCE-7M3", Decoder: "RawSpeed"},
+		camera{Maker: "Sony", Model: "ILCE-7M4", Decoder: "Unknown"},
+		camera{Maker: "Sony", Model: "ILCE-7M5"},
+	)
+	options := defaultOptions()
+	options.fields = []string{"model", "status"}
+	options.unknown = true
+	options.unsupported = true
+
+	data, _, _ := prepareOutputData(cameras, options)
+	got := [][]string{}
+	for _, r := range data {
+		got = append(got, r.fields)
+	}
+	want := [][]string{{"ILCE-7M3", "RawSpeed"}, {"ILCE-7M4", "Unknown"}, {"ILCE-7M5", "Unsupported"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadRawSpeedDNGHeader(t *testing.T) {
+	tests := []struct {
+		path      string
+		hasHeader string
+		want      []dngCamera
+	}{
+		{"testdata/rawspeed-dng-headerless.csv", "auto", []dngCamera{{Maker: "DJI", Model: "FC220"}, {Maker: "Pentax", Model: "K-1"}}},
+		{"testdata/rawspeed-dng-headerless.csv", "false", []dngCamera{{Maker: "DJI", Model: "FC220"}, {Maker: "Pentax", Model: "K-1"}}},
+		{"testdata/rawspeed-dng-custom-header.csv", "auto", []dngCamera{{Maker: "Make", Model: "Camera model"}, {Maker: "DJI", Model: "FC220"}}},
+		{"testdata/rawspeed-dng-custom-header.csv", "true", []dngCamera{{Maker: "DJI", Model: "FC220"}}},
+	}
+	for _, tt := range tests {
+		options := defaultOptions()
+		options.rawspeedDNGPath = tt.path
+		options.dngHasHeader = tt.hasHeader
+		if got := readRawSpeedDNG(options); !slices.Equal(got, tt.want) {
+			t.Errorf("%v with -dng-has-header %v: got %+v, want %+v", tt.path, tt.hasHeader, got, tt.want)
+		}
+	}
+}
+
+func TestTableRowKeyDoesNotLeak(t *testing.T) {
+	cameras := camerasOf(
+		camera{Maker: "Canon", Model: "EOS R5", Decoder: "RawSpeed"},
+		camera{Maker: "Sony", Model: "ILCE-7M3", Decoder: "LibRaw"},
+	)
+	options := defaultOptions()
+	options.fields = []string{"model", "decoder"}
+	options.segments = 2
+	options.stats.table = true
+	colHeaders := defaultColumnHeaders()
+
+	data, totals, footnotes := prepareOutputData(cameras, options)
+	for _, r := range data {
+		if len(r.fields) != len(options.fields) {
+			t.Errorf("row %q has fields %q, want one per -fields entry", r.key, r.fields)
+		}
+	}
+	if data[1].key != cameraKey("Sony", "ILCE-7M3") || data[1].maker != "Sony" {
+		t.Errorf("second row key = %q, maker = %q", data[1].key, data[1].maker)
+	}
+
+	md := &strings.Builder{}
+	generateMD(md, data, totals, footnotes, colHeaders, generateStats(cameras, options), options)
+	tsv := &strings.Builder{}
+	generateTSV(tsv, data, totals, cameras, colHeaders, options)
+	for format, out := range map[string]string{"md": md.String(), "tsv": tsv.String()} {
+		if strings.Contains(out, " zzz ") {
+			t.Errorf("%v output contains the camera key:\n%v", format, out)
+		}
+	}
+	if !strings.Contains(md.String(), "## Sony") {
+		t.Errorf("md output has no Sony segment:\n%v", md.String())
+	}
+}
+
+func TestSharedModels(t *testing.T) {
+	problems = nil
+	options := defaultOptions()
+	options.rawspeedPath = "testdata/cameras-shared-model.xml"
+	options.librawPath = "testdata/imageio_libraw-shared-model.c"
+	cameras := map[string]camera{}
+	loadRawSpeed(cameras, options)
+	loadLibRaw(cameras, options)
+	checkSharedModels(cameras)
+
+	leica := cameras[cameraKey("Leica", "Q3")]
+	if leica.Decoder != "RawSpeed" || !slices.Equal(leica.Aliases, []string{"Q3 Monochrom"}) {
+		t.Errorf("Leica Q3: decoder = %q, aliases = %q, want RawSpeed and Q3 Monochrom", leica.Decoder, leica.Aliases)
+	}
+	panasonic := cameras[cameraKey("Panasonic", "Q3")]
+	if panasonic.Decoder != "LibRaw" || !slices.Equal(panasonic.Aliases, []string{"Q3 Lite", "Q3 Special"}) {
+		t.Errorf("Panasonic Q3: decoder = %q, aliases = %q, want LibRaw and Q3 Lite, Q3 Special", panasonic.Decoder, panasonic.Aliases)
+	}
+
+	shared := slices.DeleteFunc(problems, func(p problem) bool { return p.Category != "shared-model" })
+	if len(shared) != 1 || shared[0].Message != `Model "Q3" is used by the makers Leica, Panasonic` {
+		t.Errorf("shared-model problems = %+v, want one for Q3", shared)
+	}
+}
+
+func TestWriteSplitMakerFilenames(t *testing.T) {
+	cameras := camerasOf(
+		camera{Maker: "Nikon Corporation", Model: "Z 8", Decoder: "RawSpeed"},
+		camera{Maker: "Phase One", Model: "IQ180", Decoder: "RawSpeed"},
+	)
+	options := defaultOptions()
+	options.splitBy = "maker"
+	options.makerFilenamesPath = "testdata/maker-filenames.json"
+	options.output = t.TempDir()
+	writeSplit(cameras, defaultColumnHeaders(), options)
+
+	index, err := os.ReadFile(filepath.Join(options.output, "index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- [Nikon Corporation](nikon.md) (1 cameras)\n- [Phase One](phase-one.md) (1 cameras)\n"
+	if string(index) != want {
+		t.Errorf("index.md = %q, want %q", index, want)
+	}
+	for _, name := range []string{"nikon.md", "phase-one.md"} {
+		if _, err := os.Stat(filepath.Join(options.output, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestGrandTotalAfterSegments(t *testing.T) {
+	cameras := benchmarkCameras()
+	options := defaultOptions()
+	options.segments = 2
+	options.footer = true
+
+	out := &strings.Builder{}
+	data, totals, footnotes := prepareOutputData(cameras, options)
+	generateMD(out, data, totals, footnotes, defaultColumnHeaders(), generateStats(cameras, options), options)
+
+	segments, grandTotal, ok := strings.Cut(out.String(), "\n## Grand total\n\n")
+	if !ok {
+		t.Fatalf("no Grand total heading in:\n%.500s", out.String())
+	}
+	if strings.Contains(segments, "Grand total") {
+		t.Error("Grand total is part of a segment")
+	}
+	if !strings.Contains(grandTotal, "| Grand total | 2000  |") {
+		t.Errorf("grand total table = %q", grandTotal)
+	}
+	if !strings.Contains(segments, "\n| Maker    | Model      |") {
+		t.Errorf("segment columns are sized for the grand total:\n%.300s", segments)
+	}
+}
+
+func TestJSONSupportStatus(t *testing.T) {
+	options := defaultOptions()
+	options.format = "json"
+	options.fields = withSupportStatus(options.fields)
+	if want := []string{"maker", "model", "aliases", "wbpresets", "noiseprofiles", "decoder", "rssupported", "supportstatus"}; !slices.Equal(options.fields, want) {
+		t.Errorf("fields = %q, want %q", options.fields, want)
+	}
+
+	tests := []struct {
+		status string
+		want   any
+	}{
+		{"true", true},
+		{"false", false},
+		{"partial", "partial"},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		obj := jsonCamera(camera{SupportStatus: tt.status}, options)
+		if got := obj[len(obj)-1].value; got != tt.want {
+			t.Errorf("%q: supportstatus = %#v, want %#v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+const model_map_t modelMap[] = {
+  {
+    .exif_make = "Sony",
+    .exif_model = "DSC-RX100M3",
+    .clean_make = "Sony",
+    .clean_model = "DSC-RX100M3",
+    .clean_alias = "RX100 III"
+  },
+  {
+    .exif_make = "Sony",
+    .exif_model = "DSC-RX100M4",
+    .clean_make = "Sony",
+    .clean_model = "DSC-RX100M4"
+  },
+};
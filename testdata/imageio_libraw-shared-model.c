@@ -0,0 +1,9 @@
+const model_map_t modelMap[] = {
+  {
+    .exif_make = "Panasonic",
+    .exif_model = "Q3",
+    .clean_make = "Panasonic",
+    .clean_model = "Q3",
+    .clean_alias = "Q3 Lite"
+  },
+};
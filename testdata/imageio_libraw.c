@@ -0,0 +1,16 @@
+const model_map_t modelMap[] = {
+  {
+    .exif_make = "Canon",
+    .exif_model = "Canon EOS R5",
+    .clean_make = "Canon",
+    .clean_model = "EOS R5",
+    .clean_alias = "eos r5 c"
+  },
+  {
+    .exif_make = "Sony",
+    .exif_model = "DSC-RX100M3",
+    .clean_make = "Sony",
+    .clean_model = "DSC-RX100M3",
+    .clean_alias = "RX100 III"
+  },
+};